@@ -0,0 +1,66 @@
+package gpmiddleware
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessLogConfig configures the access log emitted by the middleware, using the same label values,
+// duration and trace ID as the metrics
+type AccessLogConfig struct {
+	// Logger used, defaults to slog.Default()
+	Logger *slog.Logger
+	// Level of the log records, defaults to slog.LevelInfo
+	Level slog.Level
+	// SlowThreshold, when set, restricts the log to the requests slower than it
+	SlowThreshold time.Duration
+	// SampleRate is the fraction of the requests logged, between 0 and 1. 0 means every request
+	SampleRate float64
+}
+
+// SetAccessLog enables logging requests with log/slog
+func (p *Prometheus) SetAccessLog(cfg AccessLogConfig) {
+	if cfg.Logger == nil {
+		cfg.Logger = slog.Default()
+	}
+	p.accessLog = &cfg
+}
+
+func (cfg *AccessLogConfig) log(c *gin.Context, elapsed time.Duration, code, path string, outcomeLabels, outcomeValues []string, traceID string) {
+	if elapsed < cfg.SlowThreshold {
+		return
+	}
+	if cfg.SampleRate > 0 && cfg.SampleRate < 1 && rand.Float64() >= cfg.SampleRate {
+		return
+	}
+
+	ctx := context.Background()
+	if c.Request != nil {
+		ctx = c.Request.Context()
+	}
+	if !cfg.Logger.Enabled(ctx, cfg.Level) {
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, 6+len(outcomeLabels))
+	attrs = append(attrs,
+		slog.String("code", code),
+		slog.String("path", path),
+		slog.String("method", c.Request.Method),
+		slog.Float64("duration_seconds", elapsed.Seconds()),
+	)
+	if len(outcomeValues) > 1 {
+		attrs = append(attrs, slog.String("outcome", outcomeValues[1]))
+	}
+	for i, name := range outcomeLabels {
+		attrs = append(attrs, slog.String(name, outcomeValues[2+i]))
+	}
+	if traceID != "" {
+		attrs = append(attrs, slog.String("trace_id", traceID))
+	}
+	cfg.Logger.LogAttrs(ctx, cfg.Level, "request", attrs...)
+}
@@ -0,0 +1,74 @@
+package gpmiddleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	p.SetOutcomeLabels("reason")
+	p.SetAccessLog(AccessLogConfig{Logger: slog.New(slog.NewJSONHandler(&buf, nil))})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/pay/:id", func(c *gin.Context) {
+		SetOutcome(c, OutcomeFailure)
+		SetLabel(c, "reason", "declined")
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/pay/1", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal(err)
+	}
+	for k, want := range map[string]string{
+		"msg":      "request",
+		"code":     "200",
+		"path":     "GET_/pay/:id",
+		"method":   "GET",
+		"outcome":  "failure",
+		"reason":   "declined",
+		"trace_id": "req-1",
+	} {
+		if record[k] != want {
+			t.Errorf("%s = %v, want %s", k, record[k], want)
+		}
+	}
+	if _, ok := record["duration_seconds"].(float64); !ok {
+		t.Errorf("missing duration in %v", record)
+	}
+}
+
+func TestAccessLogSlowOnly(t *testing.T) {
+	var buf bytes.Buffer
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	p.SetAccessLog(AccessLogConfig{
+		Logger:        slog.New(slog.NewJSONHandler(&buf, nil)),
+		SlowThreshold: time.Hour,
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if buf.Len() != 0 {
+		t.Errorf("expected fast requests not to be logged, got %s", buf.String())
+	}
+}
@@ -0,0 +1,180 @@
+package gpmiddleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	apdexSlots     = 10
+	minApdexWindow = apdexSlots * time.Millisecond
+)
+
+var (
+	defaultApdexT      = 500 * time.Millisecond
+	defaultApdexWindow = 5 * time.Minute
+)
+
+// ApdexConfig configures the Apdex of the routes: requests are satisfied within T, tolerating
+// within 4T and frustrated above or when failed
+type ApdexConfig struct {
+	// T is the default target time. Defaults to 500ms
+	T time.Duration
+	// Routes overrides T for the routes, keyed by c.FullPath()
+	Routes map[string]time.Duration
+	// Window of the rolling apdex_score gauge. Defaults to 5m, and is at least 10ms
+	Window time.Duration
+}
+
+type apdexSlot struct {
+	id                    int64
+	satisfied, tolerating uint64
+	total                 uint64
+}
+
+// apdex computes the rolling Apdex of every route over apdexSlots slots of the window
+type apdex struct {
+	cfg  ApdexConfig
+	slot time.Duration
+	name string
+	help string
+	desc *prometheus.Desc
+
+	mtx    sync.Mutex
+	routes map[string]*[apdexSlots]apdexSlot
+}
+
+// SetApdex enables counting requests by Apdex zone in apdex_requests_total, and exposing the rolling
+// Apdex score of every route in apdex_score
+func (p *Prometheus) SetApdex(cfg ApdexConfig) error {
+	if cfg.T <= 0 {
+		cfg.T = defaultApdexT
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaultApdexWindow
+	} else if cfg.Window < minApdexWindow {
+		cfg.Window = minApdexWindow
+	}
+
+	if p.apdexRequests == nil {
+		apdexRequests := newCounterVec(prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "apdex_requests_total",
+			Help:      "Requests by Apdex zone: satisfied, tolerating or frustrated",
+		}, []string{"path", "zone"})
+		apdexRequests.values = map[string][]string{"zone": {"satisfied", "tolerating", "frustrated"}}
+		if err := p.register(apdexRequests); err != nil {
+			return err
+		}
+		p.apdexRequests = apdexRequests
+	}
+
+	a := &apdex{
+		cfg:    cfg,
+		slot:   cfg.Window / apdexSlots,
+		name:   prometheus.BuildFQName("", p.subsystem, "apdex_score"),
+		help:   "Rolling Apdex score of the route",
+		routes: map[string]*[apdexSlots]apdexSlot{},
+	}
+	a.desc = prometheus.NewDesc(a.name, a.help, []string{"path"}, nil)
+	if p.apdex != nil {
+		p.unregister(p.apdex)
+	}
+	if err := p.registerLocal(a); err != nil {
+		if p.apdex != nil {
+			p.registerLocal(p.apdex)
+		}
+		return err
+	}
+	p.apdex = a
+	return nil
+}
+
+// zone returns the Apdex zone of a request of the route
+func (a *apdex) zone(route string, elapsed float64, failed bool) string {
+	t, ok := a.cfg.Routes[route]
+	if !ok {
+		t = a.cfg.T
+	}
+	switch {
+	case failed || elapsed > 4*t.Seconds():
+		return "frustrated"
+	case elapsed > t.Seconds():
+		return "tolerating"
+	default:
+		return "satisfied"
+	}
+}
+
+func (a *apdex) add(path, zone string, now time.Time) {
+	id := now.UnixNano() / int64(a.slot)
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	slots, ok := a.routes[path]
+	if !ok {
+		slots = &[apdexSlots]apdexSlot{}
+		a.routes[path] = slots
+	}
+	s := &slots[id%apdexSlots]
+	if s.id != id {
+		*s = apdexSlot{id: id}
+	}
+	switch zone {
+	case "satisfied":
+		s.satisfied++
+	case "tolerating":
+		s.tolerating++
+	}
+	s.total++
+}
+
+// scores returns the Apdex score of the routes having requests in the window ending at now
+func (a *apdex) scores(now time.Time) map[string]float64 {
+	current := now.UnixNano() / int64(a.slot)
+
+	a.mtx.Lock()
+	defer a.mtx.Unlock()
+
+	out := map[string]float64{}
+	for path, slots := range a.routes {
+		var satisfied, tolerating, total uint64
+		for _, s := range slots {
+			if current-s.id < apdexSlots {
+				satisfied += s.satisfied
+				tolerating += s.tolerating
+				total += s.total
+			}
+		}
+		if total == 0 {
+			delete(a.routes, path)
+			continue
+		}
+		out[path] = (float64(satisfied) + float64(tolerating)/2) / float64(total)
+	}
+	return out
+}
+
+// Describe implements prometheus.Collector
+func (a *apdex) Describe(ch chan<- *prometheus.Desc) {
+	ch <- a.desc
+}
+
+// Collect implements prometheus.Collector
+func (a *apdex) Collect(ch chan<- prometheus.Metric) {
+	for path, score := range a.scores(time.Now()) {
+		ch <- prometheus.MustNewConstMetric(a.desc, prometheus.GaugeValue, score, path)
+	}
+}
+
+// recordApdex counts the request in its Apdex zone
+func (p *Prometheus) recordApdex(c *gin.Context, path string, elapsed float64, outcome []string) {
+	failed := len(outcome) > 1 && outcome[1] == string(OutcomeFailure)
+	zone := p.apdex.zone(c.FullPath(), elapsed, failed)
+	p.count(p.apdexRequests, 1, path, zone)
+	p.apdex.add(path, zone, time.Now())
+}
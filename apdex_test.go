@@ -0,0 +1,95 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestApdexZones(t *testing.T) {
+	a := &apdex{cfg: ApdexConfig{T: 100 * time.Millisecond, Routes: map[string]time.Duration{"/slow": time.Second}}}
+
+	for _, tc := range []struct {
+		route   string
+		elapsed float64
+		failed  bool
+		want    string
+	}{
+		{"/", 0.05, false, "satisfied"},
+		{"/", 0.2, false, "tolerating"},
+		{"/", 0.5, false, "frustrated"},
+		{"/", 0.05, true, "frustrated"},
+		{"/slow", 0.5, false, "satisfied"},
+		{"/slow", 3, false, "tolerating"},
+	} {
+		if got := a.zone(tc.route, tc.elapsed, tc.failed); got != tc.want {
+			t.Errorf("%s %v failed=%v: got %s, want %s", tc.route, tc.elapsed, tc.failed, got, tc.want)
+		}
+	}
+}
+
+func TestApdexScore(t *testing.T) {
+	a := &apdex{slot: time.Second, routes: map[string]*[apdexSlots]apdexSlot{}}
+	now := time.Now()
+
+	a.add("GET_/", "satisfied", now.Add(-20*time.Second)) // out of the window
+	a.add("GET_/", "frustrated", now)
+	a.add("GET_/", "satisfied", now)
+	a.add("GET_/", "tolerating", now.Add(-5*time.Second))
+	a.add("GET_/", "satisfied", now.Add(-5*time.Second))
+
+	if got := a.scores(now)["GET_/"]; got != 0.625 {
+		t.Errorf("expected a score of 0.625, got %v", got)
+	}
+	if _, ok := a.scores(now.Add(time.Minute))["GET_/"]; ok {
+		t.Error("expected routes without requests in the window to be dropped")
+	}
+}
+
+func TestApdexMinWindow(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	if err := p.SetApdex(ApdexConfig{Window: time.Nanosecond}); err != nil {
+		t.Fatal(err)
+	}
+	if p.apdex.slot != time.Millisecond {
+		t.Fatalf("expected the window to be clamped to slots of 1ms, got %v", p.apdex.slot)
+	}
+	p.apdex.add("GET_/", "satisfied", time.Now())
+	p.apdex.scores(time.Now())
+}
+
+func TestApdex(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	if err := p.SetApdex(ApdexConfig{T: time.Second}); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/fail", func(c *gin.Context) { c.Status(http.StatusBadGateway) })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+
+	expected := `
+# HELP gin_apdex_requests_total Requests by Apdex zone: satisfied, tolerating or frustrated
+# TYPE gin_apdex_requests_total counter
+gin_apdex_requests_total{path="GET_/",zone="satisfied"} 1
+gin_apdex_requests_total{path="GET_/fail",zone="frustrated"} 1
+# HELP gin_apdex_score Rolling Apdex score of the route
+# TYPE gin_apdex_score gauge
+gin_apdex_score{path="GET_/"} 1
+gin_apdex_score{path="GET_/fail"} 0
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "gin_apdex_requests_total", "gin_apdex_score"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,276 @@
+package gpmiddleware
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// sketchAccuracy is the relative accuracy of the values tracked by latencySketch
+	sketchAccuracy = 0.01
+	// sketchMinValue is the smallest duration tracked, smaller ones are counted as zero
+	sketchMinValue = 1e-6
+)
+
+var (
+	defaultBucketsPath     = "/debug/buckets"
+	defaultBucketCount     = 10
+	defaultBucketQuantiles = []float64{0.5, 0.9, 0.95, 0.99}
+
+	sketchGamma    = (1 + sketchAccuracy) / (1 - sketchAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// latencySketch is a high resolution histogram of durations with logarithmic buckets, keeping quantiles
+// within sketchAccuracy relative error in bounded memory (about 700 buckets from 1µs to 1h)
+type latencySketch struct {
+	counts map[int]uint64
+	zero   uint64
+	total  uint64
+}
+
+type sketchBin struct {
+	value float64
+	count uint64
+}
+
+func newLatencySketch() *latencySketch {
+	return &latencySketch{counts: map[int]uint64{}}
+}
+
+func (s *latencySketch) add(v float64) {
+	s.total++
+	if v <= sketchMinValue {
+		s.zero++
+		return
+	}
+	s.counts[int(math.Ceil(math.Log(v)/sketchLogGamma))]++
+}
+
+func (s *latencySketch) merge(o *latencySketch) {
+	s.total += o.total
+	s.zero += o.zero
+	for i, n := range o.counts {
+		s.counts[i] += n
+	}
+}
+
+// bins returns the non empty buckets sorted by value
+func (s *latencySketch) bins() []sketchBin {
+	bins := make([]sketchBin, 0, len(s.counts)+1)
+	if s.zero > 0 {
+		bins = append(bins, sketchBin{value: 0, count: s.zero})
+	}
+	idx := make([]int, 0, len(s.counts))
+	for i := range s.counts {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	for _, i := range idx {
+		// the middle of the bucket, in relative terms
+		bins = append(bins, sketchBin{value: 2 * math.Pow(sketchGamma, float64(i)) / (sketchGamma + 1), count: s.counts[i]})
+	}
+	return bins
+}
+
+// quantileOf returns the q-quantile of the bins between from (included) and to (excluded)
+func quantileOf(bins []sketchBin, from, to int, q float64) (float64, int) {
+	var total uint64
+	for _, b := range bins[from:to] {
+		total += b.count
+	}
+	rank := uint64(q * float64(total))
+	var acc uint64
+	for i := from; i < to; i++ {
+		acc += bins[i].count
+		if acc > rank {
+			return bins[i].value, i
+		}
+	}
+	return bins[to-1].value, to - 1
+}
+
+// BucketRecommendation gives histogram buckets fitting the durations observed on a route
+type BucketRecommendation struct {
+	Count     uint64             `json:"count"`
+	Quantiles map[string]float64 `json:"quantiles"`
+	Buckets   []float64          `json:"buckets"`
+}
+
+// BucketRecommendationConfig configures the recommendation of histogram buckets
+type BucketRecommendationConfig struct {
+	// Quantiles the buckets are placed at, so that estimating them with histogram_quantile() is accurate.
+	// Defaults to 0.5, 0.9, 0.95 and 0.99
+	Quantiles []float64
+	// Path of the endpoint served next to the metrics endpoint. Defaults to /debug/buckets
+	Path string
+}
+
+type bucketSketches struct {
+	quantiles []float64
+	path      string
+
+	mtx    sync.Mutex
+	routes map[string]*latencySketch
+}
+
+// EnableBucketRecommendation tracks the durations of every route in a high resolution sketch, to recommend
+// histogram buckets with RecommendBuckets or on /debug/buckets next to the metrics endpoint. Buckets are
+// placed at the given quantiles, defaulting to 0.5, 0.9, 0.95 and 0.99, so that estimating them with
+// histogram_quantile() is accurate. It must be called before the middleware is installed.
+func (p *Prometheus) EnableBucketRecommendation(quantiles ...float64) {
+	p.SetBucketRecommendation(BucketRecommendationConfig{Quantiles: quantiles})
+}
+
+// SetBucketRecommendation is EnableBucketRecommendation with the path of the endpoint configurable
+func (p *Prometheus) SetBucketRecommendation(cfg BucketRecommendationConfig) {
+	if len(cfg.Quantiles) == 0 {
+		cfg.Quantiles = defaultBucketQuantiles
+	}
+	if cfg.Path == "" {
+		cfg.Path = defaultBucketsPath
+	}
+	p.bucketSketches = &bucketSketches{
+		quantiles: cfg.Quantiles,
+		path:      cfg.Path,
+		routes:    map[string]*latencySketch{},
+	}
+}
+
+func (bs *bucketSketches) add(path string, v float64) {
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+
+	s, ok := bs.routes[path]
+	if !ok {
+		s = newLatencySketch()
+		bs.routes[path] = s
+	}
+	s.add(v)
+}
+
+// RecommendBuckets returns n bucket boundaries for every route, and for all routes together under the
+// empty route. It returns nil if EnableBucketRecommendation was not called.
+func (p *Prometheus) RecommendBuckets(n int) map[string]BucketRecommendation {
+	if p.bucketSketches == nil {
+		return nil
+	}
+	if n <= 0 {
+		n = defaultBucketCount
+	}
+
+	bs := p.bucketSketches
+	all := newLatencySketch()
+	out := map[string]BucketRecommendation{}
+
+	bs.mtx.Lock()
+	for route, s := range bs.routes {
+		out[route] = recommend(s.bins(), s.total, n, bs.quantiles)
+		all.merge(s)
+	}
+	bs.mtx.Unlock()
+
+	out[""] = recommend(all.bins(), all.total, n, bs.quantiles)
+	return out
+}
+
+// recommend places boundaries at the target quantiles first, then greedily splits at its median the
+// interval whose linear interpolation error is the largest, approximated by its count times its
+// logarithmic width. Boundaries are rounded to 2 significant digits.
+func recommend(bins []sketchBin, total uint64, n int, targets []float64) BucketRecommendation {
+	rec := BucketRecommendation{Count: total, Quantiles: map[string]float64{}}
+	if len(bins) == 0 {
+		return rec
+	}
+
+	for _, q := range targets {
+		v, _ := quantileOf(bins, 0, len(bins), q)
+		rec.Quantiles[strconv.FormatFloat(q, 'f', -1, 64)] = v
+	}
+
+	// indexes of the bins closing every bucket
+	cuts := map[int]bool{len(bins) - 1: true}
+	for _, q := range targets {
+		if len(cuts) >= n {
+			break
+		}
+		_, i := quantileOf(bins, 0, len(bins), q)
+		cuts[i] = true
+	}
+
+	for len(cuts) < n {
+		sorted := sortedCuts(cuts)
+		best, bestErr := -1, 0.0
+		from := 0
+		for _, to := range sorted {
+			if to > from {
+				var count uint64
+				for _, b := range bins[from : to+1] {
+					count += b.count
+				}
+				width := math.Log(bins[to].value+sketchMinValue) - math.Log(bins[from].value+sketchMinValue)
+				if e := float64(count) * width; e > bestErr {
+					best, bestErr = from, e
+				}
+			}
+			from = to + 1
+		}
+		if best < 0 {
+			break // every bucket is a single bin
+		}
+
+		to := best
+		for _, c := range sorted {
+			if c >= best {
+				to = c
+				break
+			}
+		}
+		_, mid := quantileOf(bins, best, to+1, 0.5)
+		if mid == to {
+			mid--
+		}
+		cuts[mid] = true
+	}
+
+	seen := map[float64]bool{}
+	for _, i := range sortedCuts(cuts) {
+		// rounded up from the upper bound of the bin, so that its durations stay in the bucket
+		b := roundSignificant(bins[i].value*math.Sqrt(sketchGamma), 2)
+		if b > 0 && !seen[b] {
+			seen[b] = true
+			rec.Buckets = append(rec.Buckets, b)
+		}
+	}
+	sort.Float64s(rec.Buckets)
+	return rec
+}
+
+func sortedCuts(cuts map[int]bool) []int {
+	out := make([]int, 0, len(cuts))
+	for i := range cuts {
+		out = append(out, i)
+	}
+	sort.Ints(out)
+	return out
+}
+
+func roundSignificant(v float64, digits int) float64 {
+	if v <= 0 {
+		return 0
+	}
+	scale := math.Pow(10, float64(digits)-math.Ceil(math.Log10(v)))
+	return math.Ceil(v*scale) / scale
+}
+
+func (p *Prometheus) bucketsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		n, _ := strconv.Atoi(c.Query("n"))
+		c.JSON(http.StatusOK, p.RecommendBuckets(n))
+	}
+}
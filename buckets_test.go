@@ -0,0 +1,102 @@
+package gpmiddleware
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestLatencySketchQuantiles(t *testing.T) {
+	s := newLatencySketch()
+	for i := 1; i <= 1000; i++ {
+		s.add(float64(i) / 1000)
+	}
+
+	bins := s.bins()
+	for q, want := range map[float64]float64{0.5: 0.5, 0.9: 0.9, 0.99: 0.99} {
+		got, _ := quantileOf(bins, 0, len(bins), q)
+		if math.Abs(got-want)/want > 2*sketchAccuracy {
+			t.Errorf("quantile %v = %v, want %v", q, got, want)
+		}
+	}
+}
+
+func TestRecommendBuckets(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	p.EnableBucketRecommendation()
+
+	// 90% of fast requests around 10ms, with a long tail up to 2s
+	for i := 0; i < 900; i++ {
+		p.bucketSketches.add("GET_/", 0.005+float64(i)/90000)
+	}
+	for i := 0; i < 100; i++ {
+		p.bucketSketches.add("GET_/", 0.1+float64(i)*0.019)
+	}
+
+	rec := p.RecommendBuckets(8)["GET_/"]
+	if rec.Count != 1000 {
+		t.Errorf("expected 1000 observations, got %d", rec.Count)
+	}
+	if len(rec.Buckets) == 0 || len(rec.Buckets) > 8 {
+		t.Fatalf("expected at most 8 buckets, got %v", rec.Buckets)
+	}
+	for i := 1; i < len(rec.Buckets); i++ {
+		if rec.Buckets[i] <= rec.Buckets[i-1] {
+			t.Fatalf("buckets not sorted: %v", rec.Buckets)
+		}
+	}
+	if rec.Buckets[0] >= 0.1 {
+		t.Errorf("expected buckets below 100ms, got %v", rec.Buckets)
+	}
+	if last := rec.Buckets[len(rec.Buckets)-1]; last < 1.981 {
+		t.Errorf("expected the last bucket to contain the slowest request, got %v", rec.Buckets)
+	}
+	if q := rec.Quantiles["0.5"]; q < 0.009 || q > 0.011 {
+		t.Errorf("unexpected median %v", q)
+	}
+}
+
+func TestBucketsEndpoint(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	p.EnableBucketRecommendation()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/buckets?n=5", nil))
+	var got map[string]BucketRecommendation
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["GET_/"].Count != 1 || got[""].Count != 1 {
+		t.Errorf("unexpected recommendations %v", got)
+	}
+}
+
+func TestBucketsEndpointPath(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	p.SetBucketRecommendation(BucketRecommendationConfig{Path: "/internal/buckets"})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/internal/buckets", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("expected the endpoint on the configured path, got %d", w.Code)
+	}
+	if !reflect.DeepEqual(p.bucketSketches.quantiles, defaultBucketQuantiles) {
+		t.Errorf("expected the default quantiles, got %v", p.bucketSketches.quantiles)
+	}
+}
@@ -0,0 +1,235 @@
+package gpmiddleware
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// MetricSchemaVersion is the version of the format of the metric schema exports
+const MetricSchemaVersion = 1
+
+var defaultCatalogPath = "/debug/metrics"
+
+// MetricLabel is a label of a metric, with the values it is known to take. Values is empty when they are
+// unbounded or unknown, e.g. for the status codes.
+type MetricLabel struct {
+	Name   string   `json:"name"`
+	Values []string `json:"values,omitempty"`
+}
+
+// MetricDescription describes a metric registered by the middleware
+type MetricDescription struct {
+	Name    string        `json:"name"`
+	Type    string        `json:"type"`
+	Help    string        `json:"help"`
+	Labels  []MetricLabel `json:"labels"`
+	Buckets []float64     `json:"buckets,omitempty"`
+}
+
+// MetricSchema lists the metrics of a service, to check the compatibility between its releases
+type MetricSchema struct {
+	Version int                 `json:"version"`
+	Metrics []MetricDescription `json:"metrics"`
+	// Captured is set for the schemas built from a capture of a metrics endpoint, which lacks the metrics
+	// without series
+	Captured bool `json:"captured,omitempty"`
+}
+
+// describer is implemented by the metrics of the middleware, to describe them in the catalog
+type describer interface {
+	describe() MetricDescription
+}
+
+// catalogue adds c to the metric catalog, if it describes itself
+func (p *Prometheus) catalogue(c prometheus.Collector) {
+	d, ok := c.(describer)
+	if !ok {
+		return
+	}
+	p.catalogMtx.Lock()
+	defer p.catalogMtx.Unlock()
+
+	if p.catalog == nil {
+		p.catalog = map[string]describer{}
+	}
+	p.catalog[d.describe().Name] = d
+}
+
+// registerLocal registers a metric of the middleware which is not shared between processes in
+// multiprocess mode
+func (p *Prometheus) registerLocal(c prometheus.Collector) error {
+	if err := p.registerer.Register(c); err != nil {
+		return err
+	}
+	p.catalogue(c)
+	return nil
+}
+
+func describeLabels(labels []string, values map[string][]string) []MetricLabel {
+	out := make([]MetricLabel, len(labels))
+	for i, l := range labels {
+		out[i] = MetricLabel{Name: l, Values: values[l]}
+	}
+	return out
+}
+
+func (h *histogramVec) describe() MetricDescription {
+	return MetricDescription{
+		Name:    h.name,
+		Type:    "histogram",
+		Help:    h.help,
+		Labels:  describeLabels(h.labels, h.values),
+		Buckets: h.buckets,
+	}
+}
+
+func (c *counterVec) describe() MetricDescription {
+	return MetricDescription{Name: c.name, Type: "counter", Help: c.help, Labels: describeLabels(c.labels, c.values)}
+}
+
+func (g *gaugeVec) describe() MetricDescription {
+	return MetricDescription{Name: g.name, Type: "gauge", Help: g.help, Labels: describeLabels(g.labels, g.values)}
+}
+
+func (a *apdex) describe() MetricDescription {
+	return MetricDescription{Name: a.name, Type: "gauge", Help: a.help, Labels: describeLabels([]string{"path"}, nil)}
+}
+
+func (hh *heavyHitters) describe() MetricDescription {
+	labels := describeLabels([]string{"path", "key"}, nil)
+	return MetricDescription{Name: hh.name, Type: "gauge", Help: hh.help, Labels: labels}
+}
+
+// MetricCatalog describes the metrics registered by the middleware, sorted by name. The values of the
+// route labels are the routes of the engine the middleware is installed on, "404" and the ones set with
+// SetRoute so far, unless they are mapped by ReqCntURLLabelMappingFn.
+func (p *Prometheus) MetricCatalog() []MetricDescription {
+	p.catalogMtx.Lock()
+	out := make([]MetricDescription, 0, len(p.catalog))
+	for _, d := range p.catalog {
+		out = append(out, d.describe())
+	}
+	p.catalogMtx.Unlock()
+
+	for _, m := range out {
+		for i, l := range m.Labels {
+			if len(l.Values) == 0 {
+				m.Labels[i].Values = p.labelValues(l.Name)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// labelValues returns the values the label is known to take in this instance, nil if unknown
+func (p *Prometheus) labelValues(label string) []string {
+	switch label {
+	case "path", "method", "route":
+		if p.engine == nil {
+			return nil
+		}
+		return p.knownRouteValues(p.engine.Routes(), label)
+	case "client_class":
+		if p.clientClasses == nil {
+			return nil
+		}
+		seen := map[string]bool{clientClassOther: true, clientClassUnknown: true}
+		values := []string{clientClassOther, clientClassUnknown}
+		for _, r := range p.clientClasses.rules {
+			if !seen[r.Class] {
+				seen[r.Class] = true
+				values = append(values, r.Class)
+			}
+		}
+		sort.Strings(values)
+		return values
+	}
+	return nil
+}
+
+// setRoute is a route set with SetRoute by a request
+type setRoute struct {
+	method string
+	route  string
+}
+
+// knownRouteValues returns the values of the "path", "method" or "route" label for the routes, the requests
+// of their methods matching none of them, and the routes set with SetRoute so far. They are the values known
+// at the time, not all the allowed ones, as handlers may set other routes later. It returns nil if routes
+// are mapped by ReqCntURLLabelMappingFn.
+func (p *Prometheus) knownRouteValues(routes gin.RoutesInfo, label string) []string {
+	if p.ReqCntURLLabelMappingFn != nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	var values []string
+	add := func(method, route string) {
+		v := method + "_" + route
+		if label == "method" {
+			v = method
+		} else if label == "route" {
+			v = route
+		}
+		if !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	for _, r := range routes {
+		if r.Path == p.MetricsPath || p.excludedPaths[r.Path] {
+			continue
+		}
+		add(r.Method, r.Path)
+		add(r.Method, "404")
+	}
+	p.setRoutes.Range(func(k, _ any) bool {
+		r := k.(setRoute)
+		add(r.method, r.route)
+		return true
+	})
+	sort.Strings(values)
+	return values
+}
+
+// MetricSchema returns the schema of the metrics registered by the middleware
+func (p *Prometheus) MetricSchema() MetricSchema {
+	return MetricSchema{Version: MetricSchemaVersion, Metrics: p.MetricCatalog()}
+}
+
+// ExportMetricSchema writes the schema of the metrics registered by the middleware as JSON, to be read
+// with ReadMetricSchema
+func (p *Prometheus) ExportMetricSchema(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(p.MetricSchema())
+}
+
+// ReadMetricSchema reads a schema written by ExportMetricSchema
+func ReadMetricSchema(r io.Reader) (*MetricSchema, error) {
+	var s MetricSchema
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// EnableMetricCatalog serves the metric schema on /debug/metrics next to the metrics endpoint, or on the
+// given path. It must be called before the middleware is installed.
+func (p *Prometheus) EnableMetricCatalog(path string) {
+	if path == "" {
+		path = defaultCatalogPath
+	}
+	p.catalogPath = path
+}
+
+func (p *Prometheus) catalogHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, p.MetricSchema())
+	}
+}
@@ -0,0 +1,115 @@
+package gpmiddleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestMetricCatalog(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	if err := p.SetApdex(ApdexConfig{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetSeriesTTL(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if _, err := p.NewRouteCounter("cache_hits_total", "Cache hits", "cache"); err != nil {
+		t.Fatal(err)
+	}
+	p.EnableMetricCatalog("")
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/items", func(c *gin.Context) {})
+	r.POST("/items", func(c *gin.Context) {})
+
+	metrics := map[string]MetricDescription{}
+	for _, m := range p.MetricCatalog() {
+		metrics[m.Name] = m
+	}
+	for _, name := range []string{
+		"gin_request_duration_seconds", "gin_request_outcomes_total", "gin_apdex_score", "gin_active_series",
+		"gin_cache_hits_total",
+	} {
+		if _, ok := metrics[name]; !ok {
+			t.Errorf("expected %s in the catalog", name)
+		}
+	}
+
+	if _, ok := metrics["gin_request_queue_seconds"]; ok {
+		t.Error("expected the metrics of features not enabled not to be in the catalog")
+	}
+
+	reqDur := metrics["gin_request_duration_seconds"]
+	if reqDur.Type != "histogram" || reqDur.Help != "Histogram request latencies" || !reflect.DeepEqual(reqDur.Buckets, defaultDurationBuckets) {
+		t.Errorf("unexpected description %+v", reqDur)
+	}
+	paths := []string{"GET_/items", "GET_404", "POST_/items", "POST_404"}
+	expected := []MetricLabel{{Name: "code"}, {Name: "path", Values: paths}}
+	if !reflect.DeepEqual(reqDur.Labels, expected) {
+		t.Errorf("expected labels %+v, got %+v", expected, reqDur.Labels)
+	}
+	if l := metrics["gin_apdex_requests_total"].Labels[1]; l.Name != "zone" || len(l.Values) != 3 {
+		t.Errorf("unexpected zone label %+v", l)
+	}
+	if l := metrics["gin_cache_hits_total"].Labels; len(l) != 3 || !reflect.DeepEqual(l[1].Values, []string{"/items", "404"}) {
+		t.Errorf("unexpected route counter labels %+v", l)
+	}
+
+	var buf bytes.Buffer
+	if err := p.ExportMetricSchema(&buf); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := ReadMetricSchema(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema.Version != MetricSchemaVersion || !reflect.DeepEqual(schema.Metrics, p.MetricCatalog()) {
+		t.Errorf("unexpected schema %+v", schema)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/metrics", nil))
+	var served MetricSchema
+	if err := json.Unmarshal(w.Body.Bytes(), &served); err != nil {
+		t.Fatal(err)
+	}
+	if len(served.Metrics) != len(metrics) {
+		t.Errorf("expected %d metrics served, got %d", len(metrics), len(served.Metrics))
+	}
+}
+
+func TestMetricCatalogSetRoute(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/users/:id", func(c *gin.Context) { SetRoute(c, "/users/internal") })
+
+	values := func() []string {
+		for _, m := range p.MetricCatalog() {
+			if m.Name == "gin_request_duration_seconds" {
+				return m.Labels[1].Values
+			}
+		}
+		return nil
+	}
+	if v := values(); !reflect.DeepEqual(v, []string{"GET_/users/:id", "GET_404"}) {
+		t.Errorf("unexpected paths before the route is set %v", v)
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if v := values(); !reflect.DeepEqual(v, []string{"GET_/users/:id", "GET_/users/internal", "GET_404"}) {
+		t.Errorf("unexpected paths once the route is set %v", v)
+	}
+}
@@ -0,0 +1,146 @@
+package gpmiddleware
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	defaultMaxAppVersions = 20
+
+	clientClassUnknown = "unknown"
+	clientClassOther   = "other"
+)
+
+// ClientRule classifies the requests whose User-Agent matches Pattern in Class. A capture group named
+// "version" in Pattern extracts the app version, e.g. `^MyApp/(?P<version>[0-9.]+) \(iOS`
+type ClientRule struct {
+	Class   string
+	Pattern *regexp.Regexp
+}
+
+// DefaultClientRules tell bots, command line tools and HTTP libraries, browsers and native mobile clients apart
+var DefaultClientRules = []ClientRule{
+	{Class: "bot", Pattern: regexp.MustCompile(`(?i)bot\b|crawl|spider|slurp|facebookexternalhit|preview|headless`)},
+	{Class: "tool", Pattern: regexp.MustCompile(`(?i)^(curl|wget|httpie|postman|insomnia|python-requests|python-urllib|aiohttp|go-http-client|java|apache-httpclient|axios|node-fetch|ruby)\b`)},
+	{Class: "web", Pattern: regexp.MustCompile(`^Mozilla/`)},
+	{Class: "ios", Pattern: regexp.MustCompile(`(?i)cfnetwork|darwin|\bios\b|iphone|ipad`)},
+	{Class: "android", Pattern: regexp.MustCompile(`(?i)okhttp|dalvik|android`)},
+}
+
+// ClientClassConfig configures the classification of requests by client from their User-Agent
+type ClientClassConfig struct {
+	// Rules are matched in order against the User-Agent, before DefaultClientRules
+	Rules []ClientRule
+	// DisableDefaultRules only matches Rules. Unmatched requests are classified as "other", and the ones
+	// without User-Agent as "unknown"
+	DisableDefaultRules bool
+	// MaxAppVersions per class recorded in requests_by_app_version_total, above which versions are
+	// recorded as "other". Defaults to 20
+	MaxAppVersions int
+}
+
+type clientClasses struct {
+	cfg      ClientClassConfig
+	rules    []ClientRule
+	requests *counterVec
+	versions *counterVec
+
+	mtx  sync.Mutex
+	seen map[string]map[string]bool
+}
+
+// SetClientClasses enables counting requests by path and client class in requests_by_client_total, and
+// by client class and app version, for the rules extracting it, in requests_by_app_version_total
+func (p *Prometheus) SetClientClasses(cfg ClientClassConfig) error {
+	if cfg.MaxAppVersions <= 0 {
+		cfg.MaxAppVersions = defaultMaxAppVersions
+	}
+	rules := cfg.Rules
+	if !cfg.DisableDefaultRules {
+		rules = append(append([]ClientRule{}, cfg.Rules...), DefaultClientRules...)
+	}
+
+	if p.clientClasses == nil {
+		cc := &clientClasses{
+			requests: newCounterVec(prometheus.CounterOpts{
+				Subsystem: p.subsystem,
+				Name:      "requests_by_client_total",
+				Help:      "Requests by client class, classified from their User-Agent",
+			}, []string{"path", "client_class"}),
+			versions: newCounterVec(prometheus.CounterOpts{
+				Subsystem: p.subsystem,
+				Name:      "requests_by_app_version_total",
+				Help:      "Requests by client class and app version, extracted from their User-Agent",
+			}, []string{"client_class", "app_version"}),
+		}
+		if err := p.registerAll(p.register, cc.requests, cc.versions); err != nil {
+			return err
+		}
+		p.clientClasses = cc
+	}
+
+	cc := p.clientClasses
+	cc.mtx.Lock()
+	cc.cfg, cc.rules = cfg, rules
+	cc.seen = map[string]map[string]bool{}
+	cc.mtx.Unlock()
+	return nil
+}
+
+// ClassifyUserAgent returns the client class of ua and its app version, empty when not extracted. Only
+// DefaultClientRules are matched if SetClientClasses was not called.
+func (p *Prometheus) ClassifyUserAgent(ua string) (class, version string) {
+	if ua == "" {
+		return clientClassUnknown, ""
+	}
+	rules := DefaultClientRules
+	if p.clientClasses != nil {
+		p.clientClasses.mtx.Lock()
+		rules = p.clientClasses.rules
+		p.clientClasses.mtx.Unlock()
+	}
+
+	for _, r := range rules {
+		m := r.Pattern.FindStringSubmatch(ua)
+		if m == nil {
+			continue
+		}
+		if i := r.Pattern.SubexpIndex("version"); i >= 0 {
+			version = m[i]
+		}
+		return r.Class, version
+	}
+	return clientClassOther, ""
+}
+
+// appVersion bounds the app versions recorded for class to MaxAppVersions, the first ones seen
+func (cc *clientClasses) appVersion(class, version string) string {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+
+	versions, ok := cc.seen[class]
+	if !ok {
+		versions = map[string]bool{}
+		cc.seen[class] = versions
+	}
+	if !versions[version] {
+		if len(versions) >= cc.cfg.MaxAppVersions {
+			return clientClassOther
+		}
+		versions[version] = true
+	}
+	return version
+}
+
+// recordClientClass counts the request by client class and app version
+func (p *Prometheus) recordClientClass(c *gin.Context, path string) {
+	class, version := p.ClassifyUserAgent(c.Request.UserAgent())
+	p.count(p.clientClasses.requests, 1, path, class)
+	if version != "" {
+		p.count(p.clientClasses.versions, 1, class, p.clientClasses.appVersion(class, version))
+	}
+}
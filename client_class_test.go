@@ -0,0 +1,82 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestClassifyUserAgent(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	err := p.SetClientClasses(ClientClassConfig{
+		Rules: []ClientRule{
+			{Class: "app_ios", Pattern: regexp.MustCompile(`^Shop/(?P<version>[0-9.]+) \(iOS`)},
+			{Class: "internal", Pattern: regexp.MustCompile(`^backoffice/`)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		ua, class, version string
+	}{
+		{"Shop/4.12.0 (iOS 17.2; iPhone14,2)", "app_ios", "4.12.0"},
+		{"backoffice/1.0", "internal", ""},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "bot", ""},
+		{"curl/8.4.0", "tool", ""},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 Safari/605.1.15", "web", ""},
+		{"Shop/1 CFNetwork/1474 Darwin/23.0.0", "ios", ""},
+		{"okhttp/4.12.0", "android", ""},
+		{"something", "other", ""},
+		{"", "unknown", ""},
+	} {
+		class, version := p.ClassifyUserAgent(tc.ua)
+		if class != tc.class || version != tc.version {
+			t.Errorf("%q: expected %s %q, got %s %q", tc.ua, tc.class, tc.version, class, version)
+		}
+	}
+}
+
+func TestClientClassMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	err := p.SetClientClasses(ClientClassConfig{
+		Rules:          []ClientRule{{Class: "app", Pattern: regexp.MustCompile(`^Shop/(?P<version>[0-9.]+)`)}},
+		MaxAppVersions: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, ua := range []string{"Shop/1.0", "Shop/1.1", "Shop/1.2", "Shop/1.0", "curl/8.4.0"} {
+		req := httptest.NewRequest(http.MethodGet, "/items", nil)
+		req.Header.Set("User-Agent", ua)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if n := testutil.ToFloat64(p.clientClasses.requests.WithLabelValues("GET_/items", "app")); n != 4 {
+		t.Errorf("expected 4 app requests, got %v", n)
+	}
+	if n := testutil.ToFloat64(p.clientClasses.requests.WithLabelValues("GET_/items", "tool")); n != 1 {
+		t.Errorf("expected 1 tool request, got %v", n)
+	}
+	for version, expected := range map[string]float64{"1.0": 2, "1.1": 1, "other": 1} {
+		if n := testutil.ToFloat64(p.clientClasses.versions.WithLabelValues("app", version)); n != expected {
+			t.Errorf("expected %v requests of version %s, got %v", expected, version, n)
+		}
+	}
+	if n := testutil.CollectAndCount(p.clientClasses.versions); n != 3 {
+		t.Errorf("expected the app versions to be bounded, got %d series", n)
+	}
+}
@@ -0,0 +1,160 @@
+package gpmiddleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	connectionLifetimeBuckets = []float64{0.1, 1, 5, 15, 30, 60, 300, 900, 3600}
+	connectionRequestsBuckets = []float64{1, 2, 5, 10, 25, 50, 100, 250, 1000}
+)
+
+type connStatsKey struct{}
+
+// connStats are the stats of a single connection, shared with its requests through their context
+type connStats struct {
+	opened   time.Time
+	state    http.ConnState
+	requests atomic.Int64
+}
+
+type connTracker struct {
+	p        *Prometheus
+	states   *gaugeVec
+	opened   *counterVec
+	hijacked *counterVec
+	lifetime *histogramVec
+	requests *histogramVec
+
+	mtx   sync.Mutex
+	conns map[net.Conn]*connStats
+}
+
+// InstrumentServer records the connections of srv, which serves e: the number of connections by state
+// (new, active or idle), the connections opened and hijacked, and the lifetime and number of requests
+// of closed connections. It chains the ConnState and ConnContext hooks already set on srv, and wraps its
+// handler, e by default, to count the requests: it must be called once the handler is set.
+func (p *Prometheus) InstrumentServer(srv *http.Server, e *gin.Engine) error {
+	t := &connTracker{
+		p: p,
+		states: newGaugeVec(prometheus.GaugeOpts{
+			Subsystem: p.subsystem,
+			Name:      "connections",
+			Help:      "Current HTTP connections by state",
+		}, []string{"state"}),
+		opened: newCounterVec(prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "connections_opened_total",
+			Help:      "HTTP connections accepted",
+		}, nil),
+		hijacked: newCounterVec(prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "connections_hijacked_total",
+			Help:      "HTTP connections hijacked, e.g. for websockets",
+		}, nil),
+		lifetime: newHistogramVec(prometheus.HistogramOpts{
+			Subsystem: p.subsystem,
+			Name:      "connection_lifetime_seconds",
+			Help:      "Histogram of the lifetime of closed HTTP connections",
+			Buckets:   connectionLifetimeBuckets,
+		}, nil),
+		requests: newHistogramVec(prometheus.HistogramOpts{
+			Subsystem: p.subsystem,
+			Name:      "connection_requests",
+			Help:      "Histogram of the requests served by closed HTTP connections",
+			Buckets:   connectionRequestsBuckets,
+		}, nil),
+		conns: map[net.Conn]*connStats{},
+	}
+	t.states.values = map[string][]string{"state": {"new", "active", "idle"}}
+	if err := p.registerAll(p.register, t.states, t.opened, t.hijacked, t.lifetime, t.requests); err != nil {
+		return err
+	}
+
+	connState := srv.ConnState
+	srv.ConnState = func(conn net.Conn, state http.ConnState) {
+		t.track(conn, state)
+		if connState != nil {
+			connState(conn, state)
+		}
+	}
+	connContext := srv.ConnContext
+	srv.ConnContext = func(ctx context.Context, conn net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, conn)
+		}
+		return context.WithValue(ctx, connStatsKey{}, t.stats(conn))
+	}
+	handler := srv.Handler
+	if handler == nil {
+		handler = e
+	}
+	srv.Handler = countConnectionRequests(handler)
+
+	return nil
+}
+
+// stats returns the stats of conn, ConnContext being called before the first state change
+func (t *connTracker) stats(conn net.Conn) *connStats {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	s, ok := t.conns[conn]
+	if !ok {
+		s = &connStats{opened: time.Now(), state: -1}
+		t.conns[conn] = s
+	}
+	return s
+}
+
+func (t *connTracker) track(conn net.Conn, state http.ConnState) {
+	s := t.stats(conn)
+
+	t.mtx.Lock()
+	previous := s.state
+	s.state = state
+	if state == http.StateClosed || state == http.StateHijacked {
+		delete(t.conns, conn)
+	}
+	t.mtx.Unlock()
+
+	if previous == state {
+		return
+	}
+	if previous == http.StateNew || previous == http.StateActive || previous == http.StateIdle {
+		t.p.addGauge(t.states, -1, previous.String())
+	}
+
+	switch state {
+	case http.StateNew:
+		t.p.count(t.opened, 1)
+		t.p.addGauge(t.states, 1, state.String())
+	case http.StateActive, http.StateIdle:
+		t.p.addGauge(t.states, 1, state.String())
+	case http.StateHijacked:
+		t.p.count(t.hijacked, 1)
+		t.p.observe(t.requests, float64(s.requests.Load()))
+	case http.StateClosed:
+		t.p.observe(t.lifetime, time.Since(s.opened).Seconds())
+		t.p.observe(t.requests, float64(s.requests.Load()))
+	}
+}
+
+// countConnectionRequests counts the requests of the connections in the server handler, as middlewares only
+// run for the routes registered after them
+func countConnectionRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s, ok := r.Context().Value(connStatsKey{}).(*connStats); ok {
+			s.requests.Add(1)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
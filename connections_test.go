@@ -0,0 +1,112 @@
+package gpmiddleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestInstrumentServer(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config = &http.Server{}
+	if err := p.InstrumentServer(srv.Config, r); err != nil {
+		t.Fatal(err)
+	}
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	srv.Start()
+
+	client := srv.Client()
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+
+	// the connection becomes idle once the response is written, possibly after the client read it
+	deadline := time.Now().Add(time.Second)
+	idle := func() float64 {
+		for _, m := range gatherSeries(t, reg, "gin_connections", map[string]string{"state": "idle"}) {
+			return m.GetGauge().GetValue()
+		}
+		return 0
+	}
+	for idle() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP gin_connections Current HTTP connections by state
+# TYPE gin_connections gauge
+gin_connections{state="active"} 0
+gin_connections{state="idle"} 1
+gin_connections{state="new"} 0
+# HELP gin_connections_opened_total HTTP connections accepted
+# TYPE gin_connections_opened_total counter
+gin_connections_opened_total 1
+`), "gin_connections", "gin_connections_opened_total"); err != nil {
+		t.Fatal(err)
+	}
+
+	srv.CloseClientConnections()
+	srv.Close()
+
+	deadline = time.Now().Add(time.Second)
+	for histogramCount(t, reg, "gin_connection_requests", nil) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := histogramCount(t, reg, "gin_connection_lifetime_seconds", nil); n != 1 {
+		t.Errorf("expected 1 closed connection, got %d", n)
+	}
+	if sum := histogramSum(t, reg, "gin_connection_requests", nil); sum != 3 {
+		t.Errorf("expected 3 requests on the connection, got %v", sum)
+	}
+}
+
+func TestInstrumentServerAfterRoutes(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	srv := httptest.NewUnstartedServer(nil)
+	srv.Config = &http.Server{}
+	if err := p.InstrumentServer(srv.Config, r); err != nil {
+		t.Fatal(err)
+	}
+	srv.Start()
+
+	client := srv.Client()
+	for i := 0; i < 2; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	srv.CloseClientConnections()
+	srv.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for histogramCount(t, reg, "gin_connection_requests", nil) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if sum := histogramSum(t, reg, "gin_connection_requests", nil); sum != 2 {
+		t.Errorf("expected 2 requests on the connection, got %v", sum)
+	}
+}
@@ -0,0 +1,107 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DeprecatedRoute is a route planned for removal
+type DeprecatedRoute struct {
+	// Method of the route, all methods when empty
+	Method string
+	// Route as returned by c.FullPath()
+	Route string
+	// Since is when the route was deprecated, sent in the Deprecation header when set
+	Since time.Time
+	// Sunset is when the route is removed, sent in the Sunset header when set
+	Sunset time.Time
+}
+
+// DeprecationConfig configures the tracking of the requests to deprecated routes
+type DeprecationConfig struct {
+	Routes []DeprecatedRoute
+	// Headers adds the Deprecation (RFC 9745) and Sunset (RFC 8594) headers to the responses of the routes
+	Headers bool
+	// CallerFn returns the caller of a request, defaults to the client class of its User-Agent
+	CallerFn func(c *gin.Context) string
+}
+
+type deprecations struct {
+	cfg      DeprecationConfig
+	routes   map[string]*DeprecatedRoute
+	requests *counterVec
+}
+
+// SetDeprecatedRoutes enables counting the requests to deprecated routes by caller in
+// deprecated_route_requests_total, along with the sunset date of the routes. It must be called before
+// the middleware is installed.
+func (p *Prometheus) SetDeprecatedRoutes(cfg DeprecationConfig) error {
+	if cfg.CallerFn == nil {
+		cfg.CallerFn = func(c *gin.Context) string {
+			class, _ := p.ClassifyUserAgent(c.Request.UserAgent())
+			return class
+		}
+	}
+
+	d := &deprecations{
+		cfg:    cfg,
+		routes: make(map[string]*DeprecatedRoute, len(cfg.Routes)),
+	}
+	for i := range cfg.Routes {
+		r := &cfg.Routes[i]
+		d.routes[r.Method+" "+r.Route] = r
+	}
+
+	if p.deprecations != nil {
+		d.requests = p.deprecations.requests
+	} else {
+		d.requests = newCounterVec(prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "deprecated_route_requests_total",
+			Help:      "Requests to deprecated routes by caller, with the sunset date of the route",
+		}, []string{"path", "caller", "sunset"})
+		if err := p.register(d.requests); err != nil {
+			return err
+		}
+	}
+	p.deprecations = d
+	return nil
+}
+
+// deprecatedRoute returns the deprecated route of the request, if any
+func (d *deprecations) deprecatedRoute(c *gin.Context) *DeprecatedRoute {
+	route := c.FullPath()
+	if route == "" {
+		return nil
+	}
+	if r, ok := d.routes[c.Request.Method+" "+route]; ok {
+		return r
+	}
+	return d.routes[" "+route]
+}
+
+// setHeaders sets the Deprecation and Sunset headers of the response, before it is written
+func (d *deprecations) setHeaders(c *gin.Context, r *DeprecatedRoute) {
+	if !d.cfg.Headers {
+		return
+	}
+	if !r.Since.IsZero() {
+		c.Header("Deprecation", "@"+strconv.FormatInt(r.Since.Unix(), 10))
+	}
+	if !r.Sunset.IsZero() {
+		c.Header("Sunset", r.Sunset.UTC().Format(http.TimeFormat))
+	}
+}
+
+// recordDeprecated counts the request to the deprecated route by caller
+func (p *Prometheus) recordDeprecated(c *gin.Context, path string, r *DeprecatedRoute) {
+	sunset := ""
+	if !r.Sunset.IsZero() {
+		sunset = r.Sunset.UTC().Format(time.DateOnly)
+	}
+	p.count(p.deprecations.requests, 1, path, p.deprecations.cfg.CallerFn(c), sunset)
+}
@@ -0,0 +1,75 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDeprecatedRoutes(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	err := p.SetDeprecatedRoutes(DeprecationConfig{
+		Routes: []DeprecatedRoute{
+			{
+				Method: http.MethodGet,
+				Route:  "/v1/items/:id",
+				Since:  time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+				Sunset: time.Date(2026, 12, 31, 0, 0, 0, 0, time.UTC),
+			},
+			{Route: "/v1/search"},
+		},
+		Headers: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	r.GET("/v1/items/:id", ok)
+	r.DELETE("/v1/items/:id", ok)
+	r.POST("/v1/search", ok)
+
+	do := func(method, path, ua string) http.Header {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("User-Agent", ua)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Header()
+	}
+
+	h := do(http.MethodGet, "/v1/items/1", "curl/8.4.0")
+	if d := h.Get("Deprecation"); d != "@1767225600" {
+		t.Errorf("unexpected Deprecation header %q", d)
+	}
+	if s := h.Get("Sunset"); s != "Thu, 31 Dec 2026 00:00:00 GMT" {
+		t.Errorf("unexpected Sunset header %q", s)
+	}
+	do(http.MethodGet, "/v1/items/2", "Mozilla/5.0")
+	do(http.MethodPost, "/v1/search", "")
+	if h := do(http.MethodDelete, "/v1/items/1", "curl/8.4.0"); h.Get("Deprecation") != "" || h.Get("Sunset") != "" {
+		t.Errorf("expected no deprecation headers for the other methods, got %v", h)
+	}
+
+	for _, tc := range []struct {
+		path, caller, sunset string
+	}{
+		{"GET_/v1/items/:id", "tool", "2026-12-31"},
+		{"GET_/v1/items/:id", "web", "2026-12-31"},
+		{"POST_/v1/search", "unknown", ""},
+	} {
+		if n := testutil.ToFloat64(p.deprecations.requests.WithLabelValues(tc.path, tc.caller, tc.sunset)); n != 1 {
+			t.Errorf("expected 1 request to %s from %s, got %v", tc.path, tc.caller, n)
+		}
+	}
+	if n := testutil.CollectAndCount(p.deprecations.requests); n != 3 {
+		t.Errorf("expected 3 series, got %d", n)
+	}
+}
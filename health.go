@@ -0,0 +1,230 @@
+package gpmiddleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	defaultLivenessPath       = "/healthz"
+	defaultReadinessPath      = "/readyz"
+	defaultHealthCheckTimeout = time.Second
+	defaultHealthCacheTTL     = 5 * time.Second
+)
+
+// HealthCheck is a named check of a dependency of the service
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+	// Timeout of the check. Defaults to 1s
+	Timeout time.Duration
+	// Critical checks make the service not ready when failing, the others are only reported
+	Critical bool
+	// Liveness checks are run for the liveness endpoint as well, failing it when critical
+	Liveness bool
+}
+
+// HealthConfig configures the liveness and readiness endpoints served next to the metrics endpoint
+type HealthConfig struct {
+	// LivenessPath defaults to /healthz
+	LivenessPath string
+	// ReadinessPath defaults to /readyz
+	ReadinessPath string
+	// CacheTTL during which the result of a check is reused. Defaults to 5s
+	CacheTTL time.Duration
+	// IncludeInMetrics records the requests to the endpoints in the request metrics
+	IncludeInMetrics bool
+}
+
+// HealthCheckResult is the result of a check, as served by the endpoints
+type HealthCheckResult struct {
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Critical bool      `json:"critical"`
+	Duration float64   `json:"duration_seconds"`
+	Time     time.Time `json:"time"`
+}
+
+// HealthStatus is served by the liveness and readiness endpoints
+type HealthStatus struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks,omitempty"`
+}
+
+type healthCheck struct {
+	HealthCheck
+
+	mtx    sync.Mutex
+	result HealthCheckResult
+}
+
+type health struct {
+	cfg    HealthConfig
+	status *gaugeVec
+	dur    *histogramVec
+
+	mtx    sync.RWMutex
+	checks []*healthCheck
+}
+
+// SetHealthChecks serves liveness and readiness endpoints next to the metrics endpoint, running the given
+// checks and recording them in health_check_status and health_check_duration_seconds. It must be called
+// before the middleware is installed.
+func (p *Prometheus) SetHealthChecks(cfg HealthConfig, checks ...HealthCheck) error {
+	if cfg.LivenessPath == "" {
+		cfg.LivenessPath = defaultLivenessPath
+	}
+	if cfg.ReadinessPath == "" {
+		cfg.ReadinessPath = defaultReadinessPath
+	}
+	if cfg.CacheTTL <= 0 {
+		cfg.CacheTTL = defaultHealthCacheTTL
+	}
+
+	if p.health == nil {
+		h := &health{
+			status: newGaugeVec(prometheus.GaugeOpts{
+				Subsystem: p.subsystem,
+				Name:      "health_check_status",
+				Help:      "Status of the last run of the health check, 1 when passing",
+			}, []string{"check", "critical"}),
+			dur: newHistogramVec(prometheus.HistogramOpts{
+				Subsystem: p.subsystem,
+				Name:      "health_check_duration_seconds",
+				Help:      "Histogram of the duration of the health checks",
+				Buckets:   defaultDurationBuckets,
+			}, []string{"check"}),
+		}
+		h.status.values = map[string][]string{"critical": {"false", "true"}}
+		if err := p.registerAll(p.register, h.status, h.dur); err != nil {
+			return err
+		}
+		p.health = h
+	}
+
+	p.health.cfg = cfg
+	if !cfg.IncludeInMetrics {
+		p.excludePaths(cfg.LivenessPath, cfg.ReadinessPath)
+	}
+	for _, c := range checks {
+		if err := p.AddHealthCheck(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddHealthCheck adds a check to the ones set with SetHealthChecks, which is called with the default
+// config if it was not yet
+func (p *Prometheus) AddHealthCheck(check HealthCheck) error {
+	if p.health == nil {
+		if err := p.SetHealthChecks(HealthConfig{}); err != nil {
+			return err
+		}
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = defaultHealthCheckTimeout
+	}
+
+	p.health.mtx.Lock()
+	p.health.checks = append(p.health.checks, &healthCheck{HealthCheck: check})
+	p.health.mtx.Unlock()
+	return nil
+}
+
+// runHealthCheck returns the result of the check, running it when the cached one expired
+func (p *Prometheus) runHealthCheck(hc *healthCheck) HealthCheckResult {
+	hc.mtx.Lock()
+	defer hc.mtx.Unlock()
+
+	if !hc.result.Time.IsZero() && time.Since(hc.result.Time) < p.health.cfg.CacheTTL {
+		return hc.result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), hc.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() { errs <- hc.Check(ctx) }()
+
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	elapsed := time.Since(start).Seconds()
+
+	hc.result = HealthCheckResult{Status: "ok", Critical: hc.Critical, Duration: elapsed, Time: start}
+	status := 1.0
+	if err != nil {
+		hc.result.Status, hc.result.Error = "fail", err.Error()
+		status = 0
+	}
+
+	critical := "false"
+	if hc.Critical {
+		critical = "true"
+	}
+	p.setGauge(p.health.status, status, hc.Name, critical)
+	p.observe(p.health.dur, elapsed, hc.Name)
+
+	return hc.result
+}
+
+// HealthStatus runs the checks, only the liveness ones unless readiness is set, and returns their status
+func (p *Prometheus) HealthStatus(readiness bool) HealthStatus {
+	status := HealthStatus{Status: "ok"}
+	if readiness && p.readyFn != nil && !p.readyFn() {
+		status.Status = "fail"
+	}
+	if p.health == nil {
+		return status
+	}
+
+	p.health.mtx.RLock()
+	var checks []*healthCheck
+	for _, hc := range p.health.checks {
+		if readiness || hc.Liveness {
+			checks = append(checks, hc)
+		}
+	}
+	p.health.mtx.RUnlock()
+
+	results := make([]HealthCheckResult, len(checks))
+	var wg sync.WaitGroup
+	for i, hc := range checks {
+		wg.Add(1)
+		go func(i int, hc *healthCheck) {
+			defer wg.Done()
+			results[i] = p.runHealthCheck(hc)
+		}(i, hc)
+	}
+	wg.Wait()
+
+	status.Checks = make(map[string]HealthCheckResult, len(checks))
+	for i, hc := range checks {
+		status.Checks[hc.Name] = results[i]
+		if results[i].Status != "ok" && hc.Critical {
+			status.Status = "fail"
+		}
+	}
+	return status
+}
+
+func (p *Prometheus) healthHandler(readiness bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		status := p.HealthStatus(readiness)
+		code := http.StatusOK
+		if status.Status != "ok" {
+			code = http.StatusServiceUnavailable
+		}
+		c.JSON(code, status)
+	}
+}
@@ -0,0 +1,142 @@
+package gpmiddleware
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHealthChecks(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+
+	var dbRuns atomic.Int32
+	dbErr := errors.New("connection refused")
+	err := p.SetHealthChecks(HealthConfig{},
+		HealthCheck{
+			Name:     "db",
+			Critical: true,
+			Check: func(ctx context.Context) error {
+				dbRuns.Add(1)
+				return dbErr
+			},
+		},
+		HealthCheck{
+			Name:    "cache",
+			Timeout: 10 * time.Millisecond,
+			Check: func(ctx context.Context) error {
+				<-ctx.Done()
+				return nil
+			},
+		},
+		HealthCheck{
+			Name:     "goroutines",
+			Liveness: true,
+			Critical: true,
+			Check:    func(ctx context.Context) error { return nil },
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+
+	get := func(path string) (int, HealthStatus) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		var status HealthStatus
+		if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		return w.Code, status
+	}
+
+	code, status := get("/healthz")
+	if code != http.StatusOK || len(status.Checks) != 1 || status.Checks["goroutines"].Status != "ok" {
+		t.Errorf("unexpected liveness %d %+v", code, status)
+	}
+
+	code, status = get("/readyz")
+	if code != http.StatusServiceUnavailable || status.Status != "fail" {
+		t.Errorf("unexpected readiness %d %+v", code, status)
+	}
+	if c := status.Checks["db"]; c.Status != "fail" || c.Error != "connection refused" || !c.Critical {
+		t.Errorf("unexpected db check %+v", c)
+	}
+	if c := status.Checks["cache"]; c.Status != "fail" || c.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("unexpected cache check %+v", c)
+	}
+
+	get("/readyz")
+	if n := dbRuns.Load(); n != 1 {
+		t.Errorf("expected the cached result to be used, the check ran %d times", n)
+	}
+
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP gin_health_check_status Status of the last run of the health check, 1 when passing
+# TYPE gin_health_check_status gauge
+gin_health_check_status{check="cache",critical="false"} 0
+gin_health_check_status{check="db",critical="true"} 0
+gin_health_check_status{check="goroutines",critical="true"} 1
+`), "gin_health_check_status"); err != nil {
+		t.Fatal(err)
+	}
+	if n := histogramCount(t, reg, "gin_health_check_duration_seconds", map[string]string{"check": "db"}); n != 1 {
+		t.Errorf("expected 1 db check duration, got %d", n)
+	}
+	if n := testutil.CollectAndCount(p.reqDur); n != 0 {
+		t.Errorf("expected the health endpoints not to be measured, got %d series", n)
+	}
+}
+
+func TestReadinessWhileDraining(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	p.SetHealthChecks(HealthConfig{})
+
+	ready := true
+	p.readyFn = func() bool { return ready }
+	if s := p.HealthStatus(true); s.Status != "ok" {
+		t.Errorf("expected ready, got %+v", s)
+	}
+	ready = false
+	if s := p.HealthStatus(true); s.Status != "fail" {
+		t.Errorf("expected not ready, got %+v", s)
+	}
+	if s := p.HealthStatus(false); s.Status != "ok" {
+		t.Errorf("expected alive, got %+v", s)
+	}
+}
+
+func TestAddHealthCheckRegistrationError(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "gin_health_check_duration_seconds", Help: "Taken"}))
+	p := NewPrometheusWithRegistry("gin", reg)
+
+	check := HealthCheck{Name: "db", Check: func(ctx context.Context) error { return nil }}
+	if err := p.AddHealthCheck(check); err == nil {
+		t.Fatal("expected the conflicting metric to fail the registration")
+	}
+	if p.health != nil {
+		t.Error("expected the health checks not to be set")
+	}
+	status := prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gin_health_check_status",
+		Help: "Status of the last run of the health check, 1 when passing",
+	}, []string{"check", "critical"})
+	if err := reg.Register(status); err != nil {
+		t.Errorf("expected health_check_status to be unregistered, got %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package gpmiddleware
+
+import (
+	"container/heap"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	defaultHeavyHittersK      = 10
+	defaultHeavyHittersWindow = time.Minute
+	defaultHeavyHittersPath   = "/debug/heavy-hitters"
+)
+
+// HeavyHittersConfig configures the tracking of the keys, client IPs by default, sending the most requests
+// to every route
+type HeavyHittersConfig struct {
+	// K keys exposed per route. Defaults to 10
+	K int
+	// Capacity of the sketch of every route, the number of keys it tracks. The counts of the top K keys
+	// are accurate as long as they are well above the requests of the route divided by Capacity.
+	// Defaults to 10*K
+	Capacity int
+	// KeyFn returns the key of a request, defaults to c.ClientIP()
+	KeyFn func(c *gin.Context) string
+	// Routes tracked, keyed by c.FullPath(). All routes when empty
+	Routes []string
+	// Window after which the counts are reset. Defaults to 1m
+	Window time.Duration
+	// Path of the debug endpoint, defaults to /debug/heavy-hitters
+	Path string
+}
+
+// HeavyHitter is a key among the ones sending the most requests to a route. Count overestimates its
+// requests by at most Error.
+type HeavyHitter struct {
+	Key   string `json:"key"`
+	Count uint64 `json:"count"`
+	Error uint64 `json:"error"`
+}
+
+// HeavyHitters are the top keys of every route in the current window
+type HeavyHitters struct {
+	WindowStart time.Time                `json:"window_start"`
+	Routes      map[string][]HeavyHitter `json:"routes"`
+}
+
+type spaceSavingEntry struct {
+	HeavyHitter
+	index int
+}
+
+// spaceSaving is a Space-Saving sketch: it counts at most capacity keys, a new key replacing the one with
+// the lowest count, whose count it inherits as its error. Entries are kept in a min-heap on their count.
+type spaceSaving struct {
+	capacity int
+	keys     map[string]*spaceSavingEntry
+	entries  []*spaceSavingEntry
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{capacity: capacity, keys: map[string]*spaceSavingEntry{}}
+}
+
+func (s *spaceSaving) Len() int           { return len(s.entries) }
+func (s *spaceSaving) Less(i, j int) bool { return s.entries[i].Count < s.entries[j].Count }
+func (s *spaceSaving) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.entries[i].index, s.entries[j].index = i, j
+}
+func (s *spaceSaving) Push(x any) {
+	e := x.(*spaceSavingEntry)
+	e.index = len(s.entries)
+	s.entries = append(s.entries, e)
+}
+func (s *spaceSaving) Pop() any {
+	e := s.entries[len(s.entries)-1]
+	s.entries = s.entries[:len(s.entries)-1]
+	return e
+}
+
+func (s *spaceSaving) add(key string) {
+	if e, ok := s.keys[key]; ok {
+		e.Count++
+		heap.Fix(s, e.index)
+		return
+	}
+	if len(s.entries) < s.capacity {
+		e := &spaceSavingEntry{HeavyHitter: HeavyHitter{Key: key, Count: 1}}
+		heap.Push(s, e)
+		s.keys[key] = e
+		return
+	}
+
+	e := s.entries[0]
+	delete(s.keys, e.Key)
+	e.Key, e.Error = key, e.Count
+	e.Count++
+	s.keys[key] = e
+	heap.Fix(s, 0)
+}
+
+// top returns the k keys with the highest counts
+func (s *spaceSaving) top(k int) []HeavyHitter {
+	out := make([]HeavyHitter, 0, len(s.entries))
+	for _, e := range s.entries {
+		out = append(out, e.HeavyHitter)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Count != out[j].Count {
+			return out[i].Count > out[j].Count
+		}
+		return out[i].Key < out[j].Key
+	})
+	if len(out) > k {
+		out = out[:k]
+	}
+	return out
+}
+
+type heavyHitters struct {
+	cfg    HeavyHittersConfig
+	routes map[string]bool
+	name   string
+	help   string
+	desc   *prometheus.Desc
+
+	mtx      sync.Mutex
+	window   int64
+	sketches map[string]*spaceSaving
+}
+
+// SetHeavyHitters enables tracking the keys sending the most requests to every route in a Space-Saving
+// sketch, exposing the top K in heavy_hitter_requests and on /debug/heavy-hitters next to the metrics
+// endpoint. It must be called before the middleware is installed.
+func (p *Prometheus) SetHeavyHitters(cfg HeavyHittersConfig) error {
+	if cfg.K <= 0 {
+		cfg.K = defaultHeavyHittersK
+	}
+	if cfg.Capacity < cfg.K {
+		cfg.Capacity = 10 * cfg.K
+	}
+	if cfg.KeyFn == nil {
+		cfg.KeyFn = (*gin.Context).ClientIP
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = defaultHeavyHittersWindow
+	}
+	if cfg.Path == "" {
+		cfg.Path = defaultHeavyHittersPath
+	}
+
+	hh := &heavyHitters{
+		cfg:      cfg,
+		name:     prometheus.BuildFQName("", p.subsystem, "heavy_hitter_requests"),
+		help:     "Estimated requests of the keys sending the most requests to the route in the current window",
+		sketches: map[string]*spaceSaving{},
+	}
+	hh.desc = prometheus.NewDesc(hh.name, hh.help, []string{"path", "key"}, nil)
+	if len(cfg.Routes) > 0 {
+		hh.routes = map[string]bool{}
+		for _, r := range cfg.Routes {
+			hh.routes[r] = true
+		}
+	}
+	if p.heavyHitters != nil {
+		p.unregister(p.heavyHitters)
+	}
+	if err := p.registerLocal(hh); err != nil {
+		if p.heavyHitters != nil {
+			p.registerLocal(p.heavyHitters)
+		}
+		return err
+	}
+	p.heavyHitters = hh
+	return nil
+}
+
+// rotate resets the sketches when the window ending at now is a new one. It must be called with mtx held.
+func (hh *heavyHitters) rotate(now time.Time) {
+	if window := now.UnixNano() / int64(hh.cfg.Window); window != hh.window {
+		hh.window = window
+		hh.sketches = map[string]*spaceSaving{}
+	}
+}
+
+func (hh *heavyHitters) add(c *gin.Context, path string, now time.Time) {
+	if hh.routes != nil && !hh.routes[c.FullPath()] {
+		return
+	}
+	key := hh.cfg.KeyFn(c)
+
+	hh.mtx.Lock()
+	defer hh.mtx.Unlock()
+
+	hh.rotate(now)
+	s, ok := hh.sketches[path]
+	if !ok {
+		s = newSpaceSaving(hh.cfg.Capacity)
+		hh.sketches[path] = s
+	}
+	s.add(key)
+}
+
+func (hh *heavyHitters) top(now time.Time) HeavyHitters {
+	hh.mtx.Lock()
+	defer hh.mtx.Unlock()
+
+	hh.rotate(now)
+	out := HeavyHitters{
+		WindowStart: time.Unix(0, hh.window*int64(hh.cfg.Window)),
+		Routes:      make(map[string][]HeavyHitter, len(hh.sketches)),
+	}
+	for path, s := range hh.sketches {
+		out.Routes[path] = s.top(hh.cfg.K)
+	}
+	return out
+}
+
+// Describe implements prometheus.Collector
+func (hh *heavyHitters) Describe(ch chan<- *prometheus.Desc) {
+	ch <- hh.desc
+}
+
+// Collect implements prometheus.Collector
+func (hh *heavyHitters) Collect(ch chan<- prometheus.Metric) {
+	for path, hitters := range hh.top(time.Now()).Routes {
+		for _, h := range hitters {
+			ch <- prometheus.MustNewConstMetric(hh.desc, prometheus.GaugeValue, float64(h.Count), path, h.Key)
+		}
+	}
+}
+
+// HeavyHitters returns the keys sending the most requests to every route in the current window. It returns
+// nil if SetHeavyHitters was not called.
+func (p *Prometheus) HeavyHitters() *HeavyHitters {
+	if p.heavyHitters == nil {
+		return nil
+	}
+	hh := p.heavyHitters.top(time.Now())
+	return &hh
+}
+
+func (p *Prometheus) heavyHittersHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, p.HeavyHitters())
+	}
+}
@@ -0,0 +1,91 @@
+package gpmiddleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestSpaceSaving(t *testing.T) {
+	s := newSpaceSaving(10)
+	for i := 0; i < 1000; i++ {
+		s.add(fmt.Sprintf("noise-%d", i))
+		if i%2 == 0 {
+			s.add("flood")
+		}
+		if i%4 == 0 {
+			s.add("busy")
+		}
+	}
+
+	top := s.top(2)
+	if len(top) != 2 || top[0].Key != "flood" || top[1].Key != "busy" {
+		t.Fatalf("unexpected top keys %+v", top)
+	}
+	if top[0].Count-top[0].Error > 500 || top[0].Count < 500 {
+		t.Errorf("expected the count of flood to bound its 500 requests, got %+v", top[0])
+	}
+	if len(s.keys) != 10 || len(s.entries) != 10 {
+		t.Errorf("expected the sketch to track 10 keys, got %d", len(s.keys))
+	}
+}
+
+func TestHeavyHitters(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	err := p.SetHeavyHitters(HeavyHittersConfig{
+		K:      2,
+		KeyFn:  func(c *gin.Context) string { return c.GetHeader("X-Caller") },
+		Routes: []string{"/search"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/search", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/items", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for path, callers := range map[string][]string{
+		"/search": {"a", "a", "a", "b", "b", "c"},
+		"/items":  {"a"},
+	} {
+		for _, caller := range callers {
+			req := httptest.NewRequest(http.MethodGet, path, nil)
+			req.Header.Set("X-Caller", caller)
+			r.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+
+	series := gatherSeries(t, reg, "gin_heavy_hitter_requests", nil)
+	if len(series) != 2 {
+		t.Fatalf("expected the top 2 keys of /search, got %v", series)
+	}
+	for _, m := range gatherSeries(t, reg, "gin_heavy_hitter_requests", map[string]string{"path": "GET_/search", "key": "a"}) {
+		if v := m.GetGauge().GetValue(); v != 3 {
+			t.Errorf("expected 3 requests of a, got %v", v)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/heavy-hitters", nil))
+	var hh HeavyHitters
+	if err := json.Unmarshal(w.Body.Bytes(), &hh); err != nil {
+		t.Fatal(err)
+	}
+	if top := hh.Routes["GET_/search"]; len(top) != 2 || top[0].Key != "a" || top[1].Key != "b" || top[1].Count != 2 {
+		t.Errorf("unexpected heavy hitters %+v", hh)
+	}
+
+	if top := p.heavyHitters.top(time.Now().Add(time.Minute)); len(top.Routes) != 0 {
+		t.Errorf("expected the counts to be reset in the next window, got %+v", top)
+	}
+}
@@ -0,0 +1,70 @@
+package gpmiddleware
+
+import (
+	"reflect"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const handlerStackKey = "gpmiddleware.handlers"
+
+// handlerFrame is an instrumented handler being executed, accumulating the time spent in the
+// instrumented handlers it called through c.Next()
+type handlerFrame struct {
+	children time.Duration
+}
+
+type handlerStack struct {
+	mtx    sync.Mutex
+	frames []*handlerFrame
+}
+
+// InstrumentHandlers wraps every handler of a chain, named after their function, see InstrumentHandler
+func (p *Prometheus) InstrumentHandlers(handlers ...gin.HandlerFunc) []gin.HandlerFunc {
+	wrapped := make([]gin.HandlerFunc, len(handlers))
+	for i, h := range handlers {
+		wrapped[i] = p.InstrumentHandler(handlerName(h), h)
+	}
+	return wrapped
+}
+
+// InstrumentHandler wraps a handler, typically a middleware, to record the time spent in it in
+// middleware_duration_seconds by route. The "inclusive" timing contains the handlers it called
+// through c.Next(), the "self" one does not contain the instrumented ones among them.
+func (p *Prometheus) InstrumentHandler(name string, h gin.HandlerFunc) gin.HandlerFunc {
+	p.middlewareOnce.Do(func() { p.register(p.middlewareDur) })
+	return func(c *gin.Context) {
+		stack, ok := c.Value(handlerStackKey).(*handlerStack)
+		if !ok {
+			stack = &handlerStack{}
+			c.Set(handlerStackKey, stack)
+		}
+
+		frame := &handlerFrame{}
+		stack.mtx.Lock()
+		stack.frames = append(stack.frames, frame)
+		stack.mtx.Unlock()
+
+		start := time.Now()
+		h(c)
+		inclusive := time.Since(start)
+
+		stack.mtx.Lock()
+		stack.frames = stack.frames[:len(stack.frames)-1]
+		if n := len(stack.frames); n > 0 {
+			stack.frames[n-1].children += inclusive
+		}
+		stack.mtx.Unlock()
+
+		path := p.pathLabel(c)
+		p.observe(p.middlewareDur, inclusive.Seconds(), path, name, "inclusive")
+		p.observe(p.middlewareDur, (inclusive - frame.children).Seconds(), path, name, "self")
+	}
+}
+
+func handlerName(h gin.HandlerFunc) string {
+	return runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+}
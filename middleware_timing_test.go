@@ -0,0 +1,56 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func authMiddleware(c *gin.Context) {
+	time.Sleep(2 * time.Millisecond)
+	c.Next()
+}
+
+func TestInstrumentHandlers(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(p.InstrumentHandlers(authMiddleware)...)
+	r.GET("/", p.InstrumentHandler("handler", func(c *gin.Context) {
+		time.Sleep(5 * time.Millisecond)
+		c.Status(http.StatusOK)
+	}))
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if n := testutil.CollectAndCount(p.middlewareDur); n != 4 {
+		t.Fatalf("expected 4 series, got %d", n)
+	}
+
+	auth := "github.com/carousell/md-gin-prometheus-middleware.authMiddleware"
+	inclusive := histogramSum(t, reg, "gin_middleware_duration_seconds", map[string]string{"middleware": auth, "timing": "inclusive"})
+	self := histogramSum(t, reg, "gin_middleware_duration_seconds", map[string]string{"middleware": auth, "timing": "self"})
+	if inclusive < 0.007 {
+		t.Errorf("expected inclusive time to contain the handler, got %v", inclusive)
+	}
+	if self < 0.002 || self >= 0.005 {
+		t.Errorf("expected self time to exclude the handler, got %v", self)
+	}
+}
+
+func histogramSum(t *testing.T, g prometheus.Gatherer, name string, labels map[string]string) float64 {
+	t.Helper()
+
+	var sum float64
+	for _, m := range gatherSeries(t, g, name, labels) {
+		sum += m.GetHistogram().GetSampleSum()
+	}
+	return sum
+}
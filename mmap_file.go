@@ -0,0 +1,65 @@
+package gpmiddleware
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"os"
+)
+
+// Layout of multiprocess files, the same as the Python client's one:
+//
+//	uint32 used bytes, 4 bytes padding
+//	entries of: uint32 key length, key, padding to 8 bytes, float64 value
+const (
+	mmapHeaderSize  = 8
+	mmapInitialSize = 1 << 20
+)
+
+var errMmapCorrupted = errors.New("corrupted multiprocess file")
+
+// mmapEntrySize returns the size of an entry with the given key length, and the offset of its value
+func mmapEntrySize(keyLen int) (size, valueOffset int) {
+	valueOffset = 4 + keyLen
+	valueOffset += (8 - valueOffset%8) % 8
+	return valueOffset + 8, valueOffset
+}
+
+// parseMmapEntries calls fn for every entry of the file content, with the offset of its value
+func parseMmapEntries(data []byte, fn func(key string, valueOffset int)) error {
+	if len(data) < mmapHeaderSize {
+		return errMmapCorrupted
+	}
+	used := int(binary.LittleEndian.Uint32(data))
+	if used > len(data) {
+		return errMmapCorrupted
+	}
+
+	for pos := mmapHeaderSize; pos < used; {
+		if pos+4 > used {
+			return errMmapCorrupted
+		}
+		keyLen := int(binary.LittleEndian.Uint32(data[pos:]))
+		size, valueOffset := mmapEntrySize(keyLen)
+		if pos+size > used {
+			return errMmapCorrupted
+		}
+		fn(string(data[pos+4:pos+4+keyLen]), pos+valueOffset)
+		pos += size
+	}
+	return nil
+}
+
+// readMmapFile reads all the values of a multiprocess file written by any process
+func readMmapFile(path string) (map[string]float64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]float64{}
+	err = parseMmapEntries(data, func(key string, off int) {
+		values[key] = math.Float64frombits(binary.LittleEndian.Uint64(data[off:]))
+	})
+	return values, err
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package gpmiddleware
+
+import "errors"
+
+type mmapFile struct{}
+
+func openMmapFile(path string) (*mmapFile, error) {
+	return nil, errors.New("multiprocess mode is only supported on unix systems")
+}
+
+func (m *mmapFile) add(key string, delta float64) {}
+
+func (m *mmapFile) set(key string, v float64) {}
+
+func (m *mmapFile) close() error { return nil }
@@ -0,0 +1,142 @@
+//go:build unix
+
+package gpmiddleware
+
+import (
+	"encoding/binary"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// mmapFile is a memory-mapped key/value file written by a single process. Values are stored
+// atomically so that readers in other processes never see torn values.
+type mmapFile struct {
+	mtx       sync.Mutex
+	f         *os.File
+	data      []byte
+	used      int
+	positions map[string]int
+}
+
+func openMmapFile(path string) (*mmapFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	size := int(st.Size())
+	if size < mmapInitialSize {
+		size = mmapInitialSize
+		if err := f.Truncate(int64(size)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	m := &mmapFile{f: f, positions: map[string]int{}}
+	if err := m.mmap(size); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	m.used = int(binary.LittleEndian.Uint32(m.data))
+	if m.used == 0 {
+		m.used = mmapHeaderSize
+		binary.LittleEndian.PutUint32(m.data, uint32(m.used))
+	}
+	// a pid may be reused by a new worker, keep appending to its file
+	if err := parseMmapEntries(m.data, func(key string, off int) { m.positions[key] = off }); err != nil {
+		m.close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *mmapFile) mmap(size int) error {
+	data, err := syscall.Mmap(int(m.f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	if err != nil {
+		return err
+	}
+	m.data = data
+	return nil
+}
+
+// position returns the offset of the value of key, appending a zero entry if missing
+func (m *mmapFile) position(key string) int {
+	if m.data == nil { // closed
+		return -1
+	}
+	if off, ok := m.positions[key]; ok {
+		return off
+	}
+
+	size, valueOffset := mmapEntrySize(len(key))
+	if m.used+size > len(m.data) {
+		newSize := len(m.data)
+		for m.used+size > newSize {
+			newSize *= 2
+		}
+		if err := m.f.Truncate(int64(newSize)); err != nil {
+			return -1
+		}
+		old := m.data
+		if err := m.mmap(newSize); err != nil {
+			return -1
+		}
+		syscall.Munmap(old)
+	}
+
+	pos := m.used
+	binary.LittleEndian.PutUint32(m.data[pos:], uint32(len(key)))
+	copy(m.data[pos+4:], key)
+	m.used += size
+	// the entry is complete before it becomes visible to readers
+	atomic.StoreUint32((*uint32)(unsafe.Pointer(&m.data[0])), uint32(m.used))
+
+	m.positions[key] = pos + valueOffset
+	return pos + valueOffset
+}
+
+func (m *mmapFile) value(off int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&m.data[off]))
+}
+
+func (m *mmapFile) add(key string, delta float64) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if off := m.position(key); off >= 0 {
+		v := math.Float64frombits(atomic.LoadUint64(m.value(off)))
+		atomic.StoreUint64(m.value(off), math.Float64bits(v+delta))
+	}
+}
+
+func (m *mmapFile) set(key string, v float64) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if off := m.position(key); off >= 0 {
+		atomic.StoreUint64(m.value(off), math.Float64bits(v))
+	}
+}
+
+func (m *mmapFile) close() error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if m.data != nil {
+		syscall.Munmap(m.data)
+		m.data = nil
+	}
+	return m.f.Close()
+}
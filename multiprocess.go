@@ -0,0 +1,418 @@
+package gpmiddleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	multiprocessCounter   = "counter"
+	multiprocessGauge     = "gauge"
+	multiprocessHistogram = "histogram"
+)
+
+// multiprocessKey identifies a single value stored in a multiprocess file
+type multiprocessKey struct {
+	Name   string   `json:"n"`
+	Help   string   `json:"h"`
+	Suffix string   `json:"s,omitempty"` // "_bucket", "_sum" or "_count" for histograms
+	Bound  string   `json:"b,omitempty"` // upper bound of a "_bucket" value
+	Labels []string `json:"l"`
+	Values []string `json:"v"`
+}
+
+func (k multiprocessKey) String() string {
+	b, _ := json.Marshal(k)
+	return string(b)
+}
+
+// multiprocessWriter writes the metrics of the current process into memory-mapped files of a
+// directory shared by all worker processes, one file per metric type and pid
+type multiprocessWriter struct {
+	dir string
+	pid int
+
+	mtx   sync.Mutex
+	files map[string]*mmapFile
+	known map[string]bool
+}
+
+func newMultiprocessWriter(dir string) (*multiprocessWriter, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &multiprocessWriter{
+		dir:   dir,
+		pid:   os.Getpid(),
+		files: map[string]*mmapFile{},
+		known: map[string]bool{},
+	}, nil
+}
+
+// seen reports whether the series was already written by this process, marking it as written
+func (w *multiprocessWriter) seen(id string) bool {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.known[id] {
+		return true
+	}
+	w.known[id] = true
+	return false
+}
+
+func (w *multiprocessWriter) file(typ string) (*mmapFile, error) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if f, ok := w.files[typ]; ok {
+		return f, nil
+	}
+	f, err := openMmapFile(filepath.Join(w.dir, fmt.Sprintf("%s_%d.db", typ, w.pid)))
+	if err != nil {
+		return nil, err
+	}
+	w.files[typ] = f
+	return f, nil
+}
+
+func (w *multiprocessWriter) addCounter(name, help string, labels, values []string, delta float64) {
+	f, err := w.file(multiprocessCounter)
+	if err != nil {
+		return
+	}
+	f.add(multiprocessKey{Name: name, Help: help, Labels: labels, Values: values}.String(), delta)
+}
+
+func (w *multiprocessWriter) setGauge(name, help string, labels, values []string, v float64) {
+	f, err := w.file(multiprocessGauge)
+	if err != nil {
+		return
+	}
+	f.set(multiprocessKey{Name: name, Help: help, Labels: labels, Values: values}.String(), v)
+}
+
+// observeHistogram stores non-cumulative bucket counts, they are accumulated when merging
+func (w *multiprocessWriter) observeHistogram(name, help string, labels, values []string, buckets []float64, v float64) {
+	f, err := w.file(multiprocessHistogram)
+	if err != nil {
+		return
+	}
+
+	key := multiprocessKey{Name: name, Help: help, Labels: labels, Values: values}
+	if id := key.String(); !w.seen(id) {
+		// every bucket has to be present in the file for the merged histogram to expose it
+		for _, b := range buckets {
+			key.Suffix, key.Bound = "_bucket", formatFloat(b)
+			f.add(key.String(), 0)
+		}
+	}
+	for _, b := range buckets {
+		if v <= b {
+			key.Suffix, key.Bound = "_bucket", formatFloat(b)
+			f.add(key.String(), 1)
+			break
+		}
+	}
+	key.Suffix, key.Bound = "_sum", ""
+	f.add(key.String(), v)
+	key.Suffix = "_count"
+	f.add(key.String(), 1)
+}
+
+func (w *multiprocessWriter) close() error {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	var err error
+	for typ, f := range w.files {
+		if cerr := f.close(); cerr != nil {
+			err = cerr
+		}
+		delete(w.files, typ)
+	}
+	return err
+}
+
+// histogramVec is a histogram of the middleware, keeping what is needed to also write it in multiprocess mode
+type histogramVec struct {
+	*prometheus.HistogramVec
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+	values  map[string][]string
+}
+
+func newHistogramVec(opts prometheus.HistogramOpts, labels []string) *histogramVec {
+	return &histogramVec{
+		HistogramVec: prometheus.NewHistogramVec(opts, labels),
+		name:         prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+		help:         opts.Help,
+		labels:       labels,
+		buckets:      opts.Buckets,
+	}
+}
+
+// observe records v in h, and in the shared files when in multiprocess mode
+func (p *Prometheus) observe(h *histogramVec, v float64, values ...string) {
+	h.WithLabelValues(values...).Observe(v)
+	if p.expiry != nil {
+		p.expiry.touch(h.name, h, values)
+	}
+	if p.multiproc != nil {
+		p.multiproc.observeHistogram(h.name, h.help, h.labels, values, h.buckets, v)
+	}
+}
+
+// counterVec is a counter of the middleware, keeping what is needed to also write it in multiprocess mode
+type counterVec struct {
+	*prometheus.CounterVec
+	name   string
+	help   string
+	labels []string
+	values map[string][]string
+}
+
+func newCounterVec(opts prometheus.CounterOpts, labels []string) *counterVec {
+	return &counterVec{
+		CounterVec: prometheus.NewCounterVec(opts, labels),
+		name:       prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+		help:       opts.Help,
+		labels:     labels,
+	}
+}
+
+// count adds v to c, and to the shared files when in multiprocess mode
+func (p *Prometheus) count(c *counterVec, v float64, values ...string) {
+	c.WithLabelValues(values...).Add(v)
+	if p.expiry != nil {
+		p.expiry.touch(c.name, c, values)
+	}
+	if p.multiproc != nil {
+		p.multiproc.addCounter(c.name, c.help, c.labels, values, v)
+	}
+}
+
+// gaugeVec is a gauge of the middleware, keeping what is needed to also write it in multiprocess mode
+type gaugeVec struct {
+	*prometheus.GaugeVec
+	name   string
+	help   string
+	labels []string
+	values map[string][]string
+}
+
+func newGaugeVec(opts prometheus.GaugeOpts, labels []string) *gaugeVec {
+	return &gaugeVec{
+		GaugeVec: prometheus.NewGaugeVec(opts, labels),
+		name:     prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+		help:     opts.Help,
+		labels:   labels,
+	}
+}
+
+// setGauge sets g to v, and in the shared files when in multiprocess mode. Gauges are not expired by
+// SetSeriesTTL, their value being a state which must outlive periods without updates.
+func (p *Prometheus) setGauge(g *gaugeVec, v float64, values ...string) {
+	g.WithLabelValues(values...).Set(v)
+	if p.multiproc != nil {
+		p.multiproc.setGauge(g.name, g.help, g.labels, values, v)
+	}
+}
+
+// addGauge adds v to g, and writes the resulting value in the shared files when in multiprocess mode
+func (p *Prometheus) addGauge(g *gaugeVec, v float64, values ...string) {
+	gauge := g.WithLabelValues(values...)
+	gauge.Add(v)
+	if p.multiproc != nil {
+		m := &dto.Metric{}
+		if err := gauge.Write(m); err == nil {
+			p.multiproc.setGauge(g.name, g.help, g.labels, values, m.GetGauge().GetValue())
+		}
+	}
+}
+
+// MultiprocessCollector merges on every scrape the metrics written by all worker processes into a
+// shared directory: counters and histograms are summed, gauges are exposed per process with a pid label
+type MultiprocessCollector struct {
+	dir string
+}
+
+// NewMultiprocessCollector creates a collector for the metrics written into dir
+func NewMultiprocessCollector(dir string) *MultiprocessCollector {
+	return &MultiprocessCollector{dir: dir}
+}
+
+// Describe sends nothing, making it an unchecked collector as metrics are only known once files are read
+func (mc *MultiprocessCollector) Describe(chan<- *prometheus.Desc) {}
+
+type multiprocessHistogramValue struct {
+	buckets map[float64]uint64
+	sum     float64
+	count   uint64
+}
+
+// Collect implements prometheus.Collector
+func (mc *MultiprocessCollector) Collect(ch chan<- prometheus.Metric) {
+	paths, err := filepath.Glob(filepath.Join(mc.dir, "*.db"))
+	if err != nil {
+		return
+	}
+
+	descs := map[string]*prometheus.Desc{}
+	desc := func(k multiprocessKey, extra ...string) *prometheus.Desc {
+		id := k.Name + "\xff" + strings.Join(k.Labels, "\xff")
+		if d, ok := descs[id]; ok {
+			return d
+		}
+		d := prometheus.NewDesc(k.Name, k.Help, append(append([]string{}, k.Labels...), extra...), nil)
+		descs[id] = d
+		return d
+	}
+
+	counters := map[string]float64{}
+	counterKeys := map[string]multiprocessKey{}
+	histograms := map[string]*multiprocessHistogramValue{}
+	histogramKeys := map[string]multiprocessKey{}
+
+	for _, path := range paths {
+		typ, pid, ok := parseMultiprocessFileName(filepath.Base(path))
+		if !ok {
+			continue
+		}
+		values, err := readMmapFile(path)
+		if err != nil {
+			continue
+		}
+
+		for raw, v := range values {
+			var k multiprocessKey
+			if err := json.Unmarshal([]byte(raw), &k); err != nil || len(k.Labels) != len(k.Values) {
+				continue
+			}
+
+			switch typ {
+			case multiprocessCounter:
+				id := k.String()
+				counters[id] += v
+				counterKeys[id] = k
+			case multiprocessGauge:
+				m, err := prometheus.NewConstMetric(desc(k, "pid"), prometheus.GaugeValue, v, append(append([]string{}, k.Values...), pid)...)
+				if err == nil {
+					ch <- m
+				}
+			case multiprocessHistogram:
+				series := multiprocessKey{Name: k.Name, Help: k.Help, Labels: k.Labels, Values: k.Values}
+				id := series.String()
+				h, ok := histograms[id]
+				if !ok {
+					h = &multiprocessHistogramValue{buckets: map[float64]uint64{}}
+					histograms[id] = h
+					histogramKeys[id] = series
+				}
+				switch k.Suffix {
+				case "_bucket":
+					if b, err := strconv.ParseFloat(k.Bound, 64); err == nil {
+						h.buckets[b] += uint64(v)
+					}
+				case "_sum":
+					h.sum += v
+				case "_count":
+					h.count += uint64(v)
+				}
+			}
+		}
+	}
+
+	for id, v := range counters {
+		k := counterKeys[id]
+		if m, err := prometheus.NewConstMetric(desc(k), prometheus.CounterValue, v, k.Values...); err == nil {
+			ch <- m
+		}
+	}
+
+	for id, h := range histograms {
+		k := histogramKeys[id]
+
+		bounds := make([]float64, 0, len(h.buckets))
+		for b := range h.buckets {
+			if !math.IsInf(b, +1) {
+				bounds = append(bounds, b)
+			}
+		}
+		sort.Float64s(bounds)
+
+		cumulative := make(map[float64]uint64, len(bounds))
+		var acc uint64
+		for _, b := range bounds {
+			acc += h.buckets[b]
+			cumulative[b] = acc
+		}
+
+		if m, err := prometheus.NewConstHistogram(desc(k), h.count, h.sum, cumulative, k.Values...); err == nil {
+			ch <- m
+		}
+	}
+}
+
+// parseMultiprocessFileName splits "<type>_<pid>.db" file names
+func parseMultiprocessFileName(name string) (typ, pid string, ok bool) {
+	name = strings.TrimSuffix(name, ".db")
+	i := strings.LastIndexByte(name, '_')
+	if i < 0 {
+		return "", "", false
+	}
+	typ, pid = name[:i], name[i+1:]
+	switch typ {
+	case multiprocessCounter, multiprocessGauge, multiprocessHistogram:
+	default:
+		return "", "", false
+	}
+	if _, err := strconv.Atoi(pid); err != nil {
+		return "", "", false
+	}
+	return typ, pid, true
+}
+
+// MarkProcessDead removes the gauge file of a worker process which exited. Counters and histograms
+// are kept so that merged values stay monotonic.
+func MarkProcessDead(dir string, pid int) error {
+	err := os.Remove(filepath.Join(dir, fmt.Sprintf("%s_%d.db", multiprocessGauge, pid)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// EnableMultiprocess writes the middleware metrics of this process into memory-mapped files in dir,
+// shared with the other pre-forked workers, and exposes the metrics of all of them merged on scrape.
+// dir should be emptied when the service (not a single worker) starts.
+func (p *Prometheus) EnableMultiprocess(dir string) error {
+	w, err := newMultiprocessWriter(dir)
+	if err != nil {
+		return err
+	}
+
+	// the merged collector already contains this process' values
+	for _, c := range p.collectors {
+		p.registerer.Unregister(c)
+	}
+	if err := p.registerer.Register(NewMultiprocessCollector(dir)); err != nil {
+		w.close()
+		return err
+	}
+
+	p.multiproc = w
+	return nil
+}
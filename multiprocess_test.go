@@ -0,0 +1,91 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMultiprocessMerge(t *testing.T) {
+	dir := t.TempDir()
+
+	// two workers writing into the same directory
+	for _, pid := range []int{100, 200} {
+		w, err := newMultiprocessWriter(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.pid = pid
+		w.observeHistogram("gin_request_duration_seconds", "Histogram request latencies",
+			[]string{"code", "path"}, []string{"200", "GET_/"}, []float64{0.1, 1}, 0.05)
+		w.observeHistogram("gin_request_duration_seconds", "Histogram request latencies",
+			[]string{"code", "path"}, []string{"200", "GET_/"}, []float64{0.1, 1}, 2)
+		w.addCounter("gin_things_total", "Things", nil, nil, 3)
+		w.setGauge("gin_in_flight", "In flight", nil, nil, float64(pid))
+		if err := w.close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := `
+# HELP gin_in_flight In flight
+# TYPE gin_in_flight gauge
+gin_in_flight{pid="100"} 100
+gin_in_flight{pid="200"} 200
+# HELP gin_request_duration_seconds Histogram request latencies
+# TYPE gin_request_duration_seconds histogram
+gin_request_duration_seconds_bucket{code="200",path="GET_/",le="0.1"} 2
+gin_request_duration_seconds_bucket{code="200",path="GET_/",le="1"} 2
+gin_request_duration_seconds_bucket{code="200",path="GET_/",le="+Inf"} 4
+gin_request_duration_seconds_sum{code="200",path="GET_/"} 4.1
+gin_request_duration_seconds_count{code="200",path="GET_/"} 4
+# HELP gin_things_total Things
+# TYPE gin_things_total counter
+gin_things_total 6
+`
+	if err := testutil.CollectAndCompare(NewMultiprocessCollector(dir), strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MarkProcessDead(dir, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.CollectAndCompare(NewMultiprocessCollector(dir), strings.NewReader(`
+# HELP gin_in_flight In flight
+# TYPE gin_in_flight gauge
+gin_in_flight{pid="200"} 200
+`), "gin_in_flight"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnableMultiprocess(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	if err := p.EnableMultiprocess(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer p.multiproc.close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for i := 0; i < 3; i++ {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	if n, err := testutil.GatherAndCount(reg, "gin_request_duration_seconds"); err != nil || n != 1 {
+		t.Fatalf("expected a single merged series, got %d (%v)", n, err)
+	}
+	mfs, _ := reg.Gather()
+	if got := mfs[0].GetMetric()[0].GetHistogram().GetSampleCount(); got != 3 {
+		t.Errorf("expected 3 observations, got %d", got)
+	}
+}
@@ -0,0 +1,132 @@
+package gpmiddleware
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const overridesKey = "gpmiddleware.overrides"
+
+// Outcome of a request, for when the status code alone is misleading
+type Outcome string
+
+const (
+	// OutcomeSuccess is the outcome of requests with a status code below 500 by default
+	OutcomeSuccess Outcome = "success"
+	// OutcomeFailure is the outcome of requests with a 5xx status code by default
+	OutcomeFailure Outcome = "failure"
+)
+
+// overrides are the label values set by handlers, read by the middleware once the request is handled.
+// It is stored as a pointer in the gin.Context keys so that copies of the context share it.
+type overrides struct {
+	mtx     sync.Mutex
+	route   string
+	outcome Outcome
+	labels  map[string]string
+}
+
+func newOverrides() *overrides {
+	return &overrides{labels: map[string]string{}}
+}
+
+func contextOverrides(c *gin.Context) *overrides {
+	if o, ok := c.Value(overridesKey).(*overrides); ok {
+		return o
+	}
+	// the middleware is not installed, keep the overrides for the handlers of this request anyway
+	o := newOverrides()
+	c.Set(overridesKey, o)
+	return o
+}
+
+// SetRoute overrides the route label of the request, e.g. to group several gin routes
+func SetRoute(c *gin.Context, route string) {
+	o := contextOverrides(c)
+	o.mtx.Lock()
+	o.route = route
+	o.mtx.Unlock()
+}
+
+// SetOutcome overrides the outcome of the request, e.g. for a 200 carrying a business failure. Outcomes
+// other than OutcomeSuccess and OutcomeFailure are ignored, to keep the series of the outcome label bounded.
+func SetOutcome(c *gin.Context, outcome Outcome) {
+	if outcome != OutcomeSuccess && outcome != OutcomeFailure {
+		return
+	}
+	o := contextOverrides(c)
+	o.mtx.Lock()
+	o.outcome = outcome
+	o.mtx.Unlock()
+}
+
+// SetLabel sets the value of one of the labels declared with SetOutcomeLabels for the request
+func SetLabel(c *gin.Context, name, value string) {
+	o := contextOverrides(c)
+	o.mtx.Lock()
+	o.labels[name] = value
+	o.mtx.Unlock()
+}
+
+// overriddenRoute returns the route set by the handlers of the request, if any
+func overriddenRoute(c *gin.Context) string {
+	o, ok := c.Value(overridesKey).(*overrides)
+	if !ok {
+		return ""
+	}
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+	return o.route
+}
+
+// SetOutcomeLabels declares labels of request_outcomes_total whose values are set by the handlers with
+// SetLabel. Requests which don't set them have empty values. It must be called before the middleware
+// is installed.
+func (p *Prometheus) SetOutcomeLabels(names ...string) error {
+	if p.outcomes != nil {
+		return errors.New("outcome labels must be set before installing the middleware")
+	}
+	p.outcomeLabels = names
+	return nil
+}
+
+// registerOutcomes registers request_outcomes_total once its labels are known
+func (p *Prometheus) registerOutcomes() {
+	p.outcomesOnce.Do(func() {
+		p.outcomes = newCounterVec(prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "request_outcomes_total",
+			Help:      "Requests by outcome, failures being 5xx unless set otherwise by the handler",
+		}, append([]string{"path", "outcome"}, p.outcomeLabels...))
+		p.outcomes.values = map[string][]string{"outcome": {string(OutcomeSuccess), string(OutcomeFailure)}}
+		p.register(p.outcomes)
+	})
+}
+
+// recordOutcome counts the request by outcome, using the overrides of the handlers, and returns the
+// label values used
+func (p *Prometheus) recordOutcome(c *gin.Context, path string, status int) []string {
+	outcome := OutcomeSuccess
+	if status >= 500 {
+		outcome = OutcomeFailure
+	}
+	values := make([]string, 2+len(p.outcomeLabels))
+
+	if o, ok := c.Value(overridesKey).(*overrides); ok {
+		o.mtx.Lock()
+		if o.outcome != "" {
+			outcome = o.outcome
+		}
+		for i, name := range p.outcomeLabels {
+			values[2+i] = o.labels[name]
+		}
+		o.mtx.Unlock()
+	}
+
+	values[0], values[1] = path, string(outcome)
+	p.count(p.outcomes, 1, values...)
+	return values
+}
@@ -0,0 +1,58 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOverrides(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	if err := p.SetOutcomeLabels("reason"); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/pay", func(c *gin.Context) {
+		SetOutcome(c, OutcomeFailure)
+		SetLabel(c, "reason", "card_declined")
+		c.JSON(http.StatusOK, "declined")
+	})
+	r.GET("/users/:id", func(c *gin.Context) {
+		SetRoute(c, "users")
+		SetOutcome(c, OutcomeSuccess)
+		c.Status(http.StatusNotFound)
+	})
+	r.GET("/boom", func(c *gin.Context) { c.Status(http.StatusInternalServerError) })
+	r.GET("/partial", func(c *gin.Context) {
+		SetOutcome(c, "partial") // ignored
+		c.Status(http.StatusOK)
+	})
+
+	for _, path := range []string{"/pay", "/users/1", "/boom", "/partial"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	expected := `
+# HELP gin_request_outcomes_total Requests by outcome, failures being 5xx unless set otherwise by the handler
+# TYPE gin_request_outcomes_total counter
+gin_request_outcomes_total{outcome="failure",path="GET_/boom",reason=""} 1
+gin_request_outcomes_total{outcome="failure",path="GET_/pay",reason="card_declined"} 1
+gin_request_outcomes_total{outcome="success",path="GET_/partial",reason=""} 1
+gin_request_outcomes_total{outcome="success",path="GET_users",reason=""} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "gin_request_outcomes_total"); err != nil {
+		t.Fatal(err)
+	}
+	if n := histogramCount(t, reg, "gin_request_duration_seconds", map[string]string{"code": "404", "path": "GET_users"}); n != 1 {
+		t.Errorf("expected the overridden route in request_duration_seconds, got %d", n)
+	}
+}
@@ -0,0 +1,91 @@
+package gpmiddleware
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const phaseRecorderKey = "gpmiddleware.phases"
+
+// Phase times a named part of a request, e.g. a DB query or a downstream call. Phases are recorded by
+// the middleware in a histogram labeled by route and phase when the request ends, registered with the
+// first phase recorded; a phase still running at that point is not recorded.
+type Phase struct {
+	recorder *phaseRecorder
+	name     string
+	start    time.Time
+	once     sync.Once
+}
+
+// StartPhase starts timing a phase of the request handled by c. It is safe to call from goroutines
+// using a copy of c (c.Copy()). Phases started with the same name in a request are summed.
+func StartPhase(c *gin.Context, name string) *Phase {
+	r, _ := c.Value(phaseRecorderKey).(*phaseRecorder)
+	return &Phase{recorder: r, name: name, start: time.Now()}
+}
+
+// StartPhase starts a phase nested in ph, recorded as "<parent>/<name>"
+func (ph *Phase) StartPhase(name string) *Phase {
+	return &Phase{recorder: ph.recorder, name: ph.name + "/" + name, start: time.Now()}
+}
+
+// Stop ends the phase. Only the first call is taken into account.
+func (ph *Phase) Stop() {
+	ph.once.Do(func() {
+		if ph.recorder != nil {
+			ph.recorder.record(ph.name, time.Since(ph.start))
+		}
+	})
+}
+
+type phaseTiming struct {
+	name     string
+	duration time.Duration
+}
+
+// phaseRecorder collects the phases of a single request. It is stored as a pointer in the gin.Context
+// keys so that copies of the context share it.
+type phaseRecorder struct {
+	mtx      sync.Mutex
+	timings  []phaseTiming
+	finished bool
+}
+
+func newPhaseRecorder() *phaseRecorder {
+	return &phaseRecorder{}
+}
+
+func (r *phaseRecorder) record(name string, d time.Duration) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	if r.finished {
+		return
+	}
+	for i := range r.timings {
+		if r.timings[i].name == name {
+			r.timings[i].duration += d
+			return
+		}
+	}
+	r.timings = append(r.timings, phaseTiming{name: name, duration: d})
+}
+
+// snapshot returns the phases recorded so far, in the order they were first stopped
+func (r *phaseRecorder) snapshot() []phaseTiming {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	return append([]phaseTiming(nil), r.timings...)
+}
+
+// finish returns the recorded phases, ignoring the ones stopped afterwards
+func (r *phaseRecorder) finish() []phaseTiming {
+	r.mtx.Lock()
+	r.finished = true
+	r.mtx.Unlock()
+
+	return r.snapshot()
+}
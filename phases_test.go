@@ -0,0 +1,98 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestPhases(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/orders/:id", func(c *gin.Context) {
+		db := StartPhase(c, "db")
+		time.Sleep(time.Millisecond)
+		query := db.StartPhase("query")
+		query.Stop()
+		db.Stop()
+		db.Stop()
+
+		done := make(chan struct{})
+		cc := c.Copy()
+		go func() {
+			defer close(done)
+			StartPhase(cc, "downstream").Stop()
+		}()
+		<-done
+
+		StartPhase(c, "cache") // never stopped
+		c.Status(http.StatusOK)
+	})
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders/1", nil))
+
+	if n := testutil.CollectAndCount(p.phaseDur); n != 3 {
+		t.Fatalf("expected 3 phases, got %d", n)
+	}
+	for _, phase := range []string{"db", "db/query", "downstream"} {
+		if n := histogramCount(t, reg, "gin_request_phase_duration_seconds", map[string]string{"path": "GET_/orders/:id", "phase": phase}); n != 1 {
+			t.Errorf("phase %s recorded %d times", phase, n)
+		}
+	}
+}
+
+// histogramCount returns the sample count of the histogram series having all the given labels
+func histogramCount(t *testing.T, g prometheus.Gatherer, name string, labels map[string]string) uint64 {
+	t.Helper()
+
+	var count uint64
+	for _, m := range gatherSeries(t, g, name, labels) {
+		count += m.GetHistogram().GetSampleCount()
+	}
+	return count
+}
+
+// gatherSeries returns the series of the metric having all the given labels
+func gatherSeries(t *testing.T, g prometheus.Gatherer, name string, labels map[string]string) []*dto.Metric {
+	t.Helper()
+
+	mfs, err := g.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []*dto.Metric
+	for _, mf := range mfs {
+		if mf.GetName() != name {
+			continue
+		}
+	metrics:
+		for _, m := range mf.GetMetric() {
+			for k, v := range labels {
+				found := false
+				for _, lp := range m.GetLabel() {
+					found = found || lp.GetName() == k && lp.GetValue() == v
+				}
+				if !found {
+					continue metrics
+				}
+			}
+			out = append(out, m)
+		}
+	}
+	return out
+}
+
+func TestPhaseOutsideMiddleware(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	StartPhase(c, "db").Stop()
+}
@@ -1,7 +1,11 @@
 package gpmiddleware
 
 import (
+	"log/slog"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -11,21 +15,80 @@ import (
 
 var defaultMetricPath = "/metrics"
 
+var defaultDurationBuckets = []float64{0.1, 0.2, 0.3, 0.5, 0.75, 1, 1.5, 2, 3, 5}
+
 // RequestCounterURLLabelMappingFn url label
 type RequestCounterURLLabelMappingFn func(c *gin.Context) string
 
 // Prometheus contains the metrics gathered by the instance and its path
 type Prometheus struct {
-	reqDur        *prometheus.HistogramVec
+	reqDur        *histogramVec
+	reqDurV2      *histogramVec
+	legacyScrapes *counterVec
+	phaseDur      *histogramVec
+	middlewareDur *histogramVec
+	queueDur      *histogramVec
+	outcomes      *counterVec
+	apdexRequests *counterVec
 	router        *gin.Engine
 	listenAddress string
 	MetricsPath   string
+
+	// ReqCntURLLabelMappingFn maps a request to its route label, defaults to the gin route (c.FullPath())
+	ReqCntURLLabelMappingFn RequestCounterURLLabelMappingFn
+	// TraceIDFn returns the trace ID of a request, defaults to the one of the traceparent or X-Request-Id header
+	TraceIDFn func(c *gin.Context) string
+
+	subsystem  string
+	registerer prometheus.Registerer
+	gatherer   prometheus.Gatherer
+
+	collectors     []prometheus.Collector
+	outcomeLabels  []string
+	outcomesOnce   sync.Once
+	phasesOnce     sync.Once
+	middlewareOnce sync.Once
+	multiproc      *multiprocessWriter
+	serverTiming   *serverTiming
+	queueTime      *QueueTimeConfig
+	slowRequests   *slowRequests
+	accessLog      *AccessLogConfig
+	expiry         *seriesExpiry
+	bucketSketches *bucketSketches
+	apdex          *apdex
+	health         *health
+	protocols      *protocols
+	clientClasses  *clientClasses
+	heavyHitters   *heavyHitters
+	deprecations   *deprecations
+	catalogMtx     sync.Mutex // guards catalog, the phases being catalogued on first use
+	catalog        map[string]describer
+	catalogPath    string
+	setRoutes      sync.Map // routes set with SetRoute, for the catalog
+	engine         *gin.Engine
+	schemas        map[Schema]bool
+	unixSocket     *UnixSocketConfig
+	readyFn        func() bool
+	excludedPaths  map[string]bool
 }
 
 // NewPrometheus generates a new set of metrics with a certain subsystem name
 func NewPrometheus(subsystem string) *Prometheus {
+	return newPrometheus(subsystem, prometheus.DefaultRegisterer, prometheus.DefaultGatherer)
+}
+
+// NewPrometheusWithRegistry generates a new set of metrics with a certain subsystem name, registered in
+// the given registry instead of the global default one
+func NewPrometheusWithRegistry(subsystem string, registry *prometheus.Registry) *Prometheus {
+	return newPrometheus(subsystem, registry, registry)
+}
+
+func newPrometheus(subsystem string, registerer prometheus.Registerer, gatherer prometheus.Gatherer) *Prometheus {
 	p := &Prometheus{
 		MetricsPath: defaultMetricPath,
+		subsystem:   subsystem,
+		registerer:  registerer,
+		gatherer:    gatherer,
 	}
 
 	p.registerMetrics(subsystem)
@@ -33,8 +96,8 @@ func NewPrometheus(subsystem string) *Prometheus {
 	return p
 }
 
-// SetListenAddress for exposing metrics on address. If not set, it will be exposed at the
-// same address of the gin engine that is being used
+// SetListenAddress for exposing metrics on address, a TCP one or a unix:// socket path. If not set, it
+// will be exposed at the same address of the gin engine that is being used
 func (p *Prometheus) SetListenAddress(address string) {
 	p.listenAddress = address
 	if p.listenAddress != "" {
@@ -54,70 +117,290 @@ func (p *Prometheus) SetListenAddressWithRouter(listenAddress string, r *gin.Eng
 // SetMetricsPath set metrics paths
 func (p *Prometheus) SetMetricsPath(e *gin.Engine) {
 	if p.listenAddress != "" {
-		p.router.GET(p.MetricsPath, prometheusHandler())
+		p.router.GET(p.MetricsPath, p.prometheusHandler())
+		p.registerEndpoints(p.router)
 		p.runServer()
 	} else {
-		e.GET(p.MetricsPath, prometheusHandler())
+		e.GET(p.MetricsPath, p.prometheusHandler())
+		p.registerEndpoints(e)
 	}
 }
 
+// excludePaths excludes requests to the given paths from the request metrics
+func (p *Prometheus) excludePaths(paths ...string) {
+	if p.excludedPaths == nil {
+		p.excludedPaths = map[string]bool{}
+	}
+	for _, path := range paths {
+		p.excludedPaths[path] = true
+	}
+}
+
+// Close stops the background work of the instance and releases its resources
+func (p *Prometheus) Close() error {
+	if p.expiry != nil {
+		p.expiry.close()
+	}
+	if p.multiproc != nil {
+		return p.multiproc.close()
+	}
+	return nil
+}
+
+// registerEndpoints adds the enabled health and debug endpoints next to the metrics one. The debug endpoints
+// are excluded from the request metrics, for their scrapes not to feed back into the metrics and sketches
+// they serve.
+func (p *Prometheus) registerEndpoints(r gin.IRoutes) {
+	if p.health != nil {
+		r.GET(p.health.cfg.LivenessPath, p.healthHandler(false))
+		r.GET(p.health.cfg.ReadinessPath, p.healthHandler(true))
+	}
+	if p.slowRequests != nil {
+		r.GET(p.slowRequests.cfg.Path, p.slowRequestsHandler())
+		p.excludePaths(p.slowRequests.cfg.Path)
+	}
+	if p.bucketSketches != nil {
+		r.GET(p.bucketSketches.path, p.bucketsHandler())
+		p.excludePaths(p.bucketSketches.path)
+	}
+	if p.heavyHitters != nil {
+		r.GET(p.heavyHitters.cfg.Path, p.heavyHittersHandler())
+		p.excludePaths(p.heavyHitters.cfg.Path)
+	}
+	if p.catalogPath != "" {
+		r.GET(p.catalogPath, p.catalogHandler())
+		p.excludePaths(p.catalogPath)
+	}
+}
+
+// runServer serves the metrics on the listen address in the background, logging with log/slog why it
+// stopped, e.g. an address already in use
 func (p *Prometheus) runServer() {
 	if p.listenAddress != "" {
-		go p.router.Run(p.listenAddress)
+		go func() {
+			l, err := p.listenMetrics()
+			if err == nil {
+				err = p.router.RunListener(l)
+			}
+			slog.Error("metrics server stopped", "address", p.listenAddress, "error", err)
+		}()
 	}
 }
 
 func (p *Prometheus) registerMetrics(subsystem string) {
-	p.reqDur = prometheus.NewHistogramVec(
+	p.reqDur = newHistogramVec(
 		prometheus.HistogramOpts{
 			Subsystem: subsystem,
 			Name:      "request_duration_seconds",
 			Help:      "Histogram request latencies",
-			Buckets:   []float64{0.1, 0.2, 0.3, 0.5, 0.75, 1, 1.5, 2, 3, 5},
+			Buckets:   defaultDurationBuckets,
 		},
 		[]string{"code", "path"},
 	)
+	p.phaseDur = newHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: subsystem,
+			Name:      "request_phase_duration_seconds",
+			Help:      "Histogram of the time spent in the phases of requests",
+			Buckets:   defaultDurationBuckets,
+		},
+		[]string{"path", "phase"},
+	)
+	p.middlewareDur = newHistogramVec(
+		prometheus.HistogramOpts{
+			Subsystem: subsystem,
+			Name:      "middleware_duration_seconds",
+			Help:      "Histogram of the time spent in instrumented handlers, inclusive or not of the following ones",
+			Buckets:   defaultDurationBuckets,
+		},
+		[]string{"path", "middleware", "timing"},
+	)
+	p.middlewareDur.values = map[string][]string{"timing": {"inclusive", "self"}}
 
-	prometheus.Register(p.reqDur)
+	p.register(p.reqDur)
+}
+
+// register registers a metric of the middleware, which is written to the shared files instead in multiprocess mode
+func (p *Prometheus) register(c prometheus.Collector) error {
+	if p.multiproc == nil { // otherwise exposed by the multiprocess collector
+		if err := p.registerer.Register(c); err != nil {
+			return err
+		}
+	}
+	p.collectors = append(p.collectors, c)
+	p.catalogue(c)
+	return nil
+}
+
+// unregister undoes register or registerLocal, e.g. when a collector registered with others cannot be kept
+// alone
+func (p *Prometheus) unregister(c prometheus.Collector) {
+	p.registerer.Unregister(c)
+	if i := slices.Index(p.collectors, c); i >= 0 {
+		p.collectors = slices.Delete(p.collectors, i, i+1)
+	}
+	if d, ok := c.(describer); ok {
+		p.catalogMtx.Lock()
+		delete(p.catalog, d.describe().Name)
+		p.catalogMtx.Unlock()
+	}
+}
+
+// registerAll registers the collectors with register, p.register or p.registerLocal, unregistering the ones
+// already registered when one fails, for a retry not to fail on them
+func (p *Prometheus) registerAll(register func(prometheus.Collector) error, cs ...prometheus.Collector) error {
+	for i, c := range cs {
+		if err := register(c); err != nil {
+			for _, registered := range cs[:i] {
+				p.unregister(registered)
+			}
+			return err
+		}
+	}
+	return nil
 }
 
 // HandlerFunc defines handler function for middleware
 func (p *Prometheus) HandlerFunc() gin.HandlerFunc {
+	p.registerOutcomes()
+
 	return func(c *gin.Context) {
-		if c.Request.URL.String() == p.MetricsPath {
+		if c.Request.URL.String() == p.MetricsPath || p.excludedPaths[c.Request.URL.Path] {
 			c.Next()
 			return
 		}
 
 		start := time.Now()
+		phases := newPhaseRecorder()
+		c.Set(phaseRecorderKey, phases)
+		c.Set(overridesKey, newOverrides())
+		stw := p.wrapServerTiming(c, start, phases)
+		var deprecated *DeprecatedRoute
+		if p.deprecations != nil {
+			if deprecated = p.deprecations.deprecatedRoute(c); deprecated != nil {
+				p.deprecations.setHeaders(c, deprecated)
+			}
+		}
 		c.Next()
+		if stw != nil {
+			// gin writes the headers of bodyless responses itself, bypassing c.Writer
+			stw.setHeader()
+			c.Writer = stw.ResponseWriter
+		}
 
-		status := strconv.Itoa(c.Writer.Status())
+		status := c.Writer.Status()
 		elapsed := float64(time.Since(start)) / float64(time.Second)
 
-		path := c.FullPath()
-		if path == "" { // path empty -> no route found
-			path = "404"
+		path := p.pathLabel(c)
+		if p.queueTime != nil {
+			if queued, ok := p.queueTime.queueDuration(c.Request, start); ok {
+				p.observe(p.queueDur, queued.Seconds(), path)
+				if p.queueTime.IncludeInLatency {
+					elapsed += queued.Seconds()
+				}
+			}
+		}
+		code := strconv.Itoa(status)
+		p.observeDuration(c, elapsed, code, path)
+		if p.bucketSketches != nil {
+			p.bucketSketches.add(path, elapsed)
+		}
+		outcome := p.recordOutcome(c, path, status)
+		if p.apdex != nil {
+			p.recordApdex(c, path, elapsed, outcome)
+		}
+		if p.protocols != nil {
+			p.recordProtocol(c, path, elapsed)
+		}
+		if p.clientClasses != nil {
+			p.recordClientClass(c, path)
+		}
+		if p.heavyHitters != nil {
+			p.heavyHitters.add(c, path, time.Now())
+		}
+		if deprecated != nil {
+			p.recordDeprecated(c, path, deprecated)
+		}
+		timings := phases.finish()
+		if len(timings) > 0 {
+			p.phasesOnce.Do(func() { p.register(p.phaseDur) })
+		}
+		for _, ph := range timings {
+			p.observe(p.phaseDur, ph.duration.Seconds(), path, ph.name)
+		}
+
+		if p.slowRequests == nil && p.accessLog == nil {
+			return
+		}
+		duration := time.Duration(elapsed * float64(time.Second))
+		traceID := p.traceID(c)
+		if p.slowRequests != nil {
+			p.slowRequests.capture(c, path, status, duration, traceID, timings)
 		}
-		p.reqDur.WithLabelValues(status, c.Request.Method+"_"+path).Observe(elapsed)
+		if p.accessLog != nil {
+			p.accessLog.log(c, duration, code, path, p.outcomeLabels, outcome, traceID)
+		}
+	}
+}
+
+// routeLabel returns the route of the request, as set by its handlers or mapped by ReqCntURLLabelMappingFn
+func (p *Prometheus) routeLabel(c *gin.Context) string {
+	if route := overriddenRoute(c); route != "" {
+		p.setRoutes.LoadOrStore(setRoute{method: c.Request.Method, route: route}, struct{}{})
+		return route
+	}
+	if p.ReqCntURLLabelMappingFn != nil {
+		return p.ReqCntURLLabelMappingFn(c)
+	}
+	path := c.FullPath()
+	if path == "" { // path empty -> no route found
+		path = "404"
 	}
+	return path
 }
 
-func prometheusHandler() gin.HandlerFunc {
+// traceID returns the trace ID of the request, as returned by TraceIDFn
+func (p *Prometheus) traceID(c *gin.Context) string {
+	if p.TraceIDFn != nil {
+		return p.TraceIDFn(c)
+	}
+	// traceparent: version-traceid-parentid-flags
+	if parts := strings.Split(c.GetHeader("traceparent"), "-"); len(parts) == 4 {
+		return parts[1]
+	}
+	return c.GetHeader("X-Request-Id")
+}
+
+// pathLabel returns the "path" label value of the request, made of its method and route
+func (p *Prometheus) pathLabel(c *gin.Context) string {
+	return c.Request.Method + "_" + p.routeLabel(c)
+}
+
+func (p *Prometheus) prometheusHandler() gin.HandlerFunc {
 	h := promhttp.Handler()
+	if p.gatherer != prometheus.DefaultGatherer {
+		h = promhttp.HandlerFor(p.gatherer, promhttp.HandlerOpts{})
+	}
 	return func(c *gin.Context) {
+		if p.emits(SchemaV2) {
+			p.serveSchemas(c, h)
+			return
+		}
 		h.ServeHTTP(c.Writer, c.Request)
 	}
 }
 
 // Use adds the middleware to a gin engine with /metrics route path.
 func (p *Prometheus) Use(e *gin.Engine) {
+	p.engine = e
 	e.Use(p.HandlerFunc())
-	e.GET(p.MetricsPath, prometheusHandler())
+	e.GET(p.MetricsPath, p.prometheusHandler())
+	p.registerEndpoints(e)
 }
 
 // UseCustom adds the middleware to a gin engine with a custom route path.
 func (p *Prometheus) UseCustom(e *gin.Engine) {
+	p.engine = e
 	e.Use(p.HandlerFunc())
 	p.SetMetricsPath(e)
 }
@@ -0,0 +1,77 @@
+package gpmiddleware
+
+import (
+	"crypto/tls"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ProtocolConfig configures the metrics of the protocols requests are served over
+type ProtocolConfig struct {
+	// Latency records request_protocol_duration_seconds by path and protocol. It is not enabled by default
+	// as it multiplies the series of every route by the number of protocols
+	Latency bool
+}
+
+type protocols struct {
+	cfg      ProtocolConfig
+	requests *counterVec
+	alpn     *counterVec
+	dur      *histogramVec
+}
+
+// SetProtocolMetrics enables counting requests by protocol (HTTP/1.1, HTTP/2.0...), TLS version and
+// cipher suite in requests_by_protocol_total, and by ALPN protocol negotiated during the TLS handshake in
+// requests_by_alpn_total. request_duration_seconds is left untouched, the latency by protocol being
+// recorded in a separate histogram when opted in.
+func (p *Prometheus) SetProtocolMetrics(cfg ProtocolConfig) error {
+	if p.protocols != nil {
+		p.protocols.cfg = cfg
+		return nil
+	}
+
+	pr := &protocols{
+		cfg: cfg,
+		requests: newCounterVec(prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "requests_by_protocol_total",
+			Help:      "Requests by protocol, TLS version and TLS cipher suite",
+		}, []string{"proto", "tls_version", "tls_cipher"}),
+		alpn: newCounterVec(prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "requests_by_alpn_total",
+			Help:      "Requests by application protocol negotiated during the TLS handshake",
+		}, []string{"alpn"}),
+		dur: newHistogramVec(prometheus.HistogramOpts{
+			Subsystem: p.subsystem,
+			Name:      "request_protocol_duration_seconds",
+			Help:      "Histogram request latencies by protocol",
+			Buckets:   defaultDurationBuckets,
+		}, []string{"path", "proto"}),
+	}
+	if err := p.registerAll(p.register, pr.requests, pr.alpn, pr.dur); err != nil {
+		return err
+	}
+	p.protocols = pr
+	return nil
+}
+
+// recordProtocol counts the request by protocol, and records its latency by protocol when enabled
+func (p *Prometheus) recordProtocol(c *gin.Context, path string, elapsed float64) {
+	proto := c.Request.Proto
+	version, cipher, alpn := "none", "none", "none"
+	if state := c.Request.TLS; state != nil {
+		version = tls.VersionName(state.Version)
+		cipher = tls.CipherSuiteName(state.CipherSuite)
+		if state.NegotiatedProtocol != "" {
+			alpn = state.NegotiatedProtocol
+		}
+	}
+
+	p.count(p.protocols.requests, 1, proto, version, cipher)
+	p.count(p.protocols.alpn, 1, alpn)
+	if p.protocols.cfg.Latency {
+		p.observe(p.protocols.dur, elapsed, path, proto)
+	}
+}
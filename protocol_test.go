@@ -0,0 +1,81 @@
+package gpmiddleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProtocolMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	if err := p.SetProtocolMetrics(ProtocolConfig{Latency: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+
+	srv := httptest.NewUnstartedServer(r)
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Proto != "HTTP/2.0" {
+		t.Fatalf("expected HTTP/2.0, got %s", resp.Proto)
+	}
+	state := resp.TLS
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+
+	series := gatherSeries(t, reg, "gin_requests_by_protocol_total", nil)
+	if len(series) != 2 {
+		t.Fatalf("expected 2 series, got %v", series)
+	}
+	if n := testutil.ToFloat64(p.protocols.requests.WithLabelValues("HTTP/2.0", "TLS 1.3", tls.CipherSuiteName(state.CipherSuite))); n != 1 {
+		t.Errorf("expected 1 HTTP/2.0 request over TLS 1.3, got %v", n)
+	}
+	if n := testutil.ToFloat64(p.protocols.requests.WithLabelValues("HTTP/1.1", "none", "none")); n != 1 {
+		t.Errorf("expected 1 plain HTTP/1.1 request, got %v", n)
+	}
+	if n := testutil.ToFloat64(p.protocols.alpn.WithLabelValues("h2")); n != 1 {
+		t.Errorf("expected 1 h2 request, got %v", n)
+	}
+	if n := histogramCount(t, reg, "gin_request_protocol_duration_seconds", map[string]string{"path": "GET_/ping", "proto": "HTTP/2.0"}); n != 1 {
+		t.Errorf("expected 1 HTTP/2.0 duration, got %d", n)
+	}
+	if n := histogramCount(t, reg, "gin_request_duration_seconds", map[string]string{"path": "GET_/ping", "code": "200"}); n != 2 {
+		t.Errorf("expected the request durations to be recorded regardless of the protocol, got %d", n)
+	}
+}
+
+func TestProtocolMetricsRegistrationRollback(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	conflict := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "gin_request_protocol_duration_seconds",
+		Help: "Histogram request latencies by protocol",
+	}, []string{"path", "proto"})
+	reg.MustRegister(conflict)
+	p := NewPrometheusWithRegistry("gin", reg)
+
+	if err := p.SetProtocolMetrics(ProtocolConfig{}); err == nil {
+		t.Fatal("expected the conflicting metric to fail the registration")
+	}
+	reg.Unregister(conflict)
+	if err := p.SetProtocolMetrics(ProtocolConfig{}); err != nil {
+		t.Fatalf("expected a retry to succeed, got %v", err)
+	}
+}
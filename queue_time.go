@@ -0,0 +1,101 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	defaultQueueTimeHeaders = []string{"X-Request-Start", "X-Queue-Start"}
+	defaultMaxQueueTime     = time.Minute
+)
+
+// QueueTimeConfig configures the measure of the time requests spent queued in the load balancer,
+// from the timestamp it sets in a header when receiving them
+type QueueTimeConfig struct {
+	// Headers holding the timestamp, the first valid one is used. Defaults to X-Request-Start and X-Queue-Start
+	Headers []string
+	// MaxQueueTime above which a queue time is considered to come from clock skew and is ignored. Defaults to 1m
+	MaxQueueTime time.Duration
+	// IncludeInLatency adds the queue time to request_duration_seconds
+	IncludeInLatency bool
+}
+
+// SetQueueTime enables recording request_queue_seconds from the load balancer timestamp header.
+// Timestamps may be prefixed with "t=" and in seconds (with a fraction), milliseconds, microseconds or
+// nanoseconds since the epoch. Negative queue times, due to clock skew, are recorded as 0.
+func (p *Prometheus) SetQueueTime(cfg QueueTimeConfig) error {
+	if len(cfg.Headers) == 0 {
+		cfg.Headers = defaultQueueTimeHeaders
+	}
+	if cfg.MaxQueueTime <= 0 {
+		cfg.MaxQueueTime = defaultMaxQueueTime
+	}
+
+	if p.queueDur == nil {
+		queueDur := newHistogramVec(prometheus.HistogramOpts{
+			Subsystem: p.subsystem,
+			Name:      "request_queue_seconds",
+			Help:      "Histogram of the time requests spent queued in the load balancer",
+			Buckets:   defaultDurationBuckets,
+		}, []string{"path"})
+		if err := p.register(queueDur); err != nil {
+			return err
+		}
+		p.queueDur = queueDur
+	}
+	p.queueTime = &cfg
+	return nil
+}
+
+// queueDuration returns the time the request was queued before reaching the middleware at start
+func (cfg *QueueTimeConfig) queueDuration(r *http.Request, start time.Time) (time.Duration, bool) {
+	for _, h := range cfg.Headers {
+		v := r.Header.Get(h)
+		if v == "" {
+			continue
+		}
+		queuedAt, ok := parseRequestStart(v)
+		if !ok {
+			continue
+		}
+
+		d := start.Sub(queuedAt)
+		if d < 0 {
+			d = 0
+		}
+		if d > cfg.MaxQueueTime {
+			return 0, false
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// parseRequestStart parses the timestamp of X-Request-Start like headers, guessing its unit from its magnitude
+func parseRequestStart(v string) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	v = strings.TrimPrefix(v, "t=")
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 {
+		return time.Time{}, false
+	}
+
+	var ns float64
+	switch {
+	case f < 1e11: // seconds, until year 5138
+		ns = f * 1e9
+	case f < 1e14: // milliseconds
+		ns = f * 1e6
+	case f < 1e17: // microseconds
+		ns = f * 1e3
+	default: // nanoseconds
+		ns = f
+	}
+	return time.Unix(0, int64(ns)), true
+}
@@ -0,0 +1,85 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestParseRequestStart(t *testing.T) {
+	want := time.Date(2024, 5, 1, 10, 0, 0, 250e6, time.UTC)
+
+	for _, v := range []string{
+		"t=1714557600.250",
+		"1714557600.25",
+		"t=1714557600250",
+		"t=1714557600250000",
+		"1714557600250000000",
+	} {
+		got, ok := parseRequestStart(v)
+		if !ok {
+			t.Errorf("%s: not parsed", v)
+			continue
+		}
+		if d := got.Sub(want); d > time.Microsecond || d < -time.Microsecond {
+			t.Errorf("%s: got %v, want %v", v, got.UTC(), want)
+		}
+	}
+
+	for _, v := range []string{"", "t=", "t=abc", "-12"} {
+		if _, ok := parseRequestStart(v); ok {
+			t.Errorf("%q: expected parse failure", v)
+		}
+	}
+}
+
+func TestQueueDurationFallback(t *testing.T) {
+	cfg := &QueueTimeConfig{Headers: defaultQueueTimeHeaders, MaxQueueTime: defaultMaxQueueTime}
+	start := time.Now()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Request-Start", "garbage")
+	req.Header.Set("X-Queue-Start", strconv.FormatInt(start.Add(-time.Second).UnixMilli(), 10))
+
+	if d, ok := cfg.queueDuration(req, start); !ok || d < time.Second || d > time.Second+time.Millisecond {
+		t.Errorf("expected the fallback header to be used, got %v %v", d, ok)
+	}
+}
+
+func TestQueueTime(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	if err := p.SetQueueTime(QueueTimeConfig{IncludeInLatency: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, header := range []string{
+		"t=" + strconv.FormatInt(time.Now().Add(-500*time.Millisecond).UnixMicro(), 10),
+		"t=" + strconv.FormatInt(time.Now().Add(time.Second).UnixMilli(), 10), // skewed clock, queued for 0
+		"t=" + strconv.FormatInt(time.Now().Add(-time.Hour).UnixMilli(), 10),  // too old, ignored
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Request-Start", header)
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	labels := map[string]string{"path": "GET_/"}
+	if n := histogramCount(t, reg, "gin_request_queue_seconds", labels); n != 2 {
+		t.Fatalf("expected 2 queue times, got %d", n)
+	}
+	if sum := histogramSum(t, reg, "gin_request_queue_seconds", labels); sum < 0.5 || sum > 0.6 {
+		t.Errorf("unexpected queue time %v", sum)
+	}
+	if sum := histogramSum(t, reg, "gin_request_duration_seconds", labels); sum < 0.5 {
+		t.Errorf("expected the queue time in the request duration, got %v", sum)
+	}
+}
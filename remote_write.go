@@ -0,0 +1,443 @@
+package gpmiddleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+var (
+	defaultRemoteWriteInterval      = 15 * time.Second
+	defaultRemoteWriteTimeout       = 10 * time.Second
+	defaultRemoteWriteMaxRetries    = 3
+	defaultRemoteWriteMinBackoff    = 100 * time.Millisecond
+	defaultRemoteWriteQueueCapacity = 10000
+	defaultRemoteWriteBatchSize     = 2000
+)
+
+// RemoteWriteConfig configures the remote_write client of a Prometheus instance
+type RemoteWriteConfig struct {
+	// URL of the remote_write endpoint
+	URL string
+	// Interval between two gathers of the registry. Defaults to 15s
+	Interval time.Duration
+	// Timeout of a single send. Defaults to 10s
+	Timeout time.Duration
+	// MaxRetries of a batch on recoverable errors (network errors, 5xx and 429). Defaults to 3
+	MaxRetries int
+	// MinBackoff is the wait before the first retry, doubled on every following one. Defaults to 100ms
+	MinBackoff time.Duration
+	// QueueCapacity is the maximum number of samples kept in memory while waiting to be sent.
+	// When full, the oldest samples are dropped. Defaults to 10000
+	QueueCapacity int
+	// MaxSamplesPerSend is the maximum number of samples in a single request. Defaults to 2000
+	MaxSamplesPerSend int
+	// ExternalLabels are added to every series sent
+	ExternalLabels map[string]string
+	// Headers are added to every request, e.g. for authorization
+	Headers map[string]string
+	// Client used for sending. Defaults to a http.Client using Timeout
+	Client *http.Client
+}
+
+// RemoteWriter periodically gathers the metrics of a Prometheus instance and pushes them to a
+// remote_write endpoint, for services which can't be scraped. Samples are buffered in a bounded
+// in-memory queue only, so they are lost on restart.
+type RemoteWriter struct {
+	cfg      RemoteWriteConfig
+	gatherer prometheus.Gatherer
+	client   *http.Client
+
+	mtx   sync.Mutex
+	queue []remoteSeries
+
+	sentSamples    prometheus.Counter
+	droppedSamples *counterVec
+	failedSends    prometheus.Counter
+	retries        prometheus.Counter
+	pendingSamples prometheus.Gauge
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// remoteSeries is a single sample of a series, mirroring prompb.TimeSeries
+type remoteSeries struct {
+	labels    []remoteLabel
+	value     float64
+	timestamp int64
+}
+
+type remoteLabel struct {
+	name  string
+	value string
+}
+
+// recoverableError is returned by send when the batch may succeed when retried
+type recoverableError struct {
+	error
+}
+
+// NewRemoteWriter creates a remote_write client pushing the metrics gathered by this instance
+func (p *Prometheus) NewRemoteWriter(cfg RemoteWriteConfig) (*RemoteWriter, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("remote write url is required")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = defaultRemoteWriteInterval
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultRemoteWriteTimeout
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = defaultRemoteWriteMaxRetries
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = defaultRemoteWriteMinBackoff
+	}
+	if cfg.QueueCapacity <= 0 {
+		cfg.QueueCapacity = defaultRemoteWriteQueueCapacity
+	}
+	if cfg.MaxSamplesPerSend <= 0 {
+		cfg.MaxSamplesPerSend = defaultRemoteWriteBatchSize
+	}
+
+	w := &RemoteWriter{
+		cfg:      cfg,
+		gatherer: p.gatherer,
+		client:   cfg.Client,
+		done:     make(chan struct{}),
+	}
+	if w.client == nil {
+		w.client = &http.Client{Timeout: cfg.Timeout}
+	}
+
+	sent := newCounterVec(prometheus.CounterOpts{
+		Subsystem: p.subsystem,
+		Name:      "remote_write_sent_samples_total",
+		Help:      "Samples successfully sent to the remote_write endpoint",
+	}, nil)
+	w.droppedSamples = newCounterVec(prometheus.CounterOpts{
+		Subsystem: p.subsystem,
+		Name:      "remote_write_dropped_samples_total",
+		Help:      "Samples dropped before reaching the remote_write endpoint",
+	}, []string{"reason"})
+	w.droppedSamples.values = map[string][]string{"reason": {"queue_full", "rejected"}}
+	failed := newCounterVec(prometheus.CounterOpts{
+		Subsystem: p.subsystem,
+		Name:      "remote_write_failed_sends_total",
+		Help:      "Batches which could not be sent after all retries",
+	}, nil)
+	retries := newCounterVec(prometheus.CounterOpts{
+		Subsystem: p.subsystem,
+		Name:      "remote_write_retries_total",
+		Help:      "Retried sends to the remote_write endpoint",
+	}, nil)
+	pending := newGaugeVec(prometheus.GaugeOpts{
+		Subsystem: p.subsystem,
+		Name:      "remote_write_pending_samples",
+		Help:      "Samples waiting in the in-memory queue",
+	}, nil)
+
+	if err := p.registerAll(p.registerLocal, sent, w.droppedSamples, failed, retries, pending); err != nil {
+		return nil, err
+	}
+	w.sentSamples, w.failedSends, w.retries = sent.WithLabelValues(), failed.WithLabelValues(), retries.WithLabelValues()
+	w.pendingSamples = pending.WithLabelValues()
+
+	return w, nil
+}
+
+// Start pushes metrics every configured interval until Stop is called
+func (w *RemoteWriter) Start() {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	if w.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+
+	go func() {
+		defer close(w.done)
+
+		ticker := time.NewTicker(w.cfg.Interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				w.Push(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the periodic push started by Start, aborting an in-flight push
+func (w *RemoteWriter) Stop() {
+	w.mtx.Lock()
+	cancel := w.cancel
+	w.mtx.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-w.done
+	}
+}
+
+// Push gathers the registry once, queues the samples and sends the whole queue.
+// Samples of batches that failed with recoverable errors stay queued for the next push.
+func (w *RemoteWriter) Push(ctx context.Context) error {
+	mfs, err := w.gatherer.Gather()
+	if err != nil && len(mfs) == 0 {
+		return err
+	}
+	w.enqueue(familiesToSeries(mfs, w.cfg.ExternalLabels, time.Now()))
+
+	return w.flush(ctx)
+}
+
+func (w *RemoteWriter) enqueue(series []remoteSeries) {
+	w.mtx.Lock()
+	defer w.mtx.Unlock()
+
+	w.queue = append(w.queue, series...)
+	if over := len(w.queue) - w.cfg.QueueCapacity; over > 0 {
+		w.droppedSamples.WithLabelValues("queue_full").Add(float64(over))
+		w.queue = append(w.queue[:0:0], w.queue[over:]...)
+	}
+	w.pendingSamples.Set(float64(len(w.queue)))
+}
+
+func (w *RemoteWriter) flush(ctx context.Context) error {
+	w.mtx.Lock()
+	pending := w.queue
+	w.queue = nil
+	w.mtx.Unlock()
+
+	var (
+		lastErr error
+		retry   []remoteSeries
+	)
+	for len(pending) > 0 {
+		n := w.cfg.MaxSamplesPerSend
+		if n > len(pending) {
+			n = len(pending)
+		}
+		batch := pending[:n]
+		pending = pending[n:]
+
+		err := w.sendWithRetries(ctx, batch)
+		switch {
+		case err == nil:
+			w.sentSamples.Add(float64(len(batch)))
+		case errors.As(err, new(recoverableError)):
+			w.failedSends.Inc()
+			retry = append(retry, batch...)
+			lastErr = err
+		default:
+			w.failedSends.Inc()
+			w.droppedSamples.WithLabelValues("rejected").Add(float64(len(batch)))
+			lastErr = err
+		}
+	}
+
+	// re-queue in front of samples gathered meanwhile, trimming to the capacity again
+	w.mtx.Lock()
+	w.queue = append(retry, w.queue...)
+	w.mtx.Unlock()
+	w.enqueue(nil)
+
+	return lastErr
+}
+
+func (w *RemoteWriter) sendWithRetries(ctx context.Context, batch []remoteSeries) error {
+	body := snappy.Encode(nil, encodeWriteRequest(batch))
+	backoff := w.cfg.MinBackoff
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = w.send(ctx, body)
+		if err == nil || !errors.As(err, new(recoverableError)) || attempt >= w.cfg.MaxRetries {
+			return err
+		}
+
+		w.retries.Inc()
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return recoverableError{ctx.Err()}
+		}
+		backoff *= 2
+	}
+}
+
+func (w *RemoteWriter) send(ctx context.Context, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, w.cfg.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range w.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return recoverableError{err}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = fmt.Errorf("remote write returned HTTP status %s", resp.Status)
+	if resp.StatusCode/100 == 5 || resp.StatusCode == http.StatusTooManyRequests {
+		return recoverableError{err}
+	}
+	return err
+}
+
+// familiesToSeries flattens gathered metric families into one sample per series, expanding
+// histograms and summaries the same way the text exposition format does
+func familiesToSeries(mfs []*dto.MetricFamily, external map[string]string, now time.Time) []remoteSeries {
+	var out []remoteSeries
+	ts := now.UnixMilli()
+
+	for _, mf := range mfs {
+		name := mf.GetName()
+		for _, m := range mf.GetMetric() {
+			t := ts
+			if m.TimestampMs != nil {
+				t = m.GetTimestampMs()
+			}
+			add := func(suffix string, v float64, extra ...string) {
+				out = append(out, remoteSeries{
+					labels:    seriesLabels(name+suffix, m.GetLabel(), external, extra...),
+					value:     v,
+					timestamp: t,
+				})
+			}
+
+			switch mf.GetType() {
+			case dto.MetricType_COUNTER:
+				add("", m.GetCounter().GetValue())
+			case dto.MetricType_GAUGE:
+				add("", m.GetGauge().GetValue())
+			case dto.MetricType_SUMMARY:
+				s := m.GetSummary()
+				for _, q := range s.GetQuantile() {
+					add("", q.GetValue(), "quantile", formatFloat(q.GetQuantile()))
+				}
+				add("_sum", s.GetSampleSum())
+				add("_count", float64(s.GetSampleCount()))
+			case dto.MetricType_HISTOGRAM:
+				h := m.GetHistogram()
+				for _, b := range h.GetBucket() {
+					if math.IsInf(b.GetUpperBound(), +1) {
+						continue
+					}
+					add("_bucket", float64(b.GetCumulativeCount()), "le", formatFloat(b.GetUpperBound()))
+				}
+				add("_bucket", float64(h.GetSampleCount()), "le", "+Inf")
+				add("_sum", h.GetSampleSum())
+				add("_count", float64(h.GetSampleCount()))
+			default:
+				add("", m.GetUntyped().GetValue())
+			}
+		}
+	}
+
+	return out
+}
+
+func seriesLabels(name string, pairs []*dto.LabelPair, external map[string]string, extra ...string) []remoteLabel {
+	labels := make([]remoteLabel, 0, len(pairs)+len(external)+len(extra)/2+1)
+	labels = append(labels, remoteLabel{name: "__name__", value: name})
+
+	seen := map[string]bool{}
+	for _, lp := range pairs {
+		labels = append(labels, remoteLabel{name: lp.GetName(), value: lp.GetValue()})
+		seen[lp.GetName()] = true
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		labels = append(labels, remoteLabel{name: extra[i], value: extra[i+1]})
+		seen[extra[i]] = true
+	}
+	// labels of the series itself take precedence over external ones, as in Prometheus
+	for k, v := range external {
+		if !seen[k] {
+			labels = append(labels, remoteLabel{name: k, value: v})
+		}
+	}
+
+	sort.Slice(labels, func(i, j int) bool { return labels[i].name < labels[j].name })
+	return labels
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// encodeWriteRequest marshals the series as a prompb.WriteRequest:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+func encodeWriteRequest(series []remoteSeries) []byte {
+	var buf, ts, msg []byte
+	for _, s := range series {
+		ts = ts[:0]
+		for _, l := range s.labels {
+			msg = msg[:0]
+			msg = protowire.AppendTag(msg, 1, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.name)
+			msg = protowire.AppendTag(msg, 2, protowire.BytesType)
+			msg = protowire.AppendString(msg, l.value)
+
+			ts = protowire.AppendTag(ts, 1, protowire.BytesType)
+			ts = protowire.AppendBytes(ts, msg)
+		}
+
+		msg = msg[:0]
+		msg = protowire.AppendTag(msg, 1, protowire.Fixed64Type)
+		msg = protowire.AppendFixed64(msg, math.Float64bits(s.value))
+		msg = protowire.AppendTag(msg, 2, protowire.VarintType)
+		msg = protowire.AppendVarint(msg, uint64(s.timestamp))
+
+		ts = protowire.AppendTag(ts, 2, protowire.BytesType)
+		ts = protowire.AppendBytes(ts, msg)
+
+		buf = protowire.AppendTag(buf, 1, protowire.BytesType)
+		buf = protowire.AppendBytes(buf, ts)
+	}
+	return buf
+}
@@ -0,0 +1,170 @@
+package gpmiddleware
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type remoteWriteReceiver struct {
+	mtx      sync.Mutex
+	series   map[string]float64
+	requests int
+	statuses []int
+}
+
+func (rr *remoteWriteReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rr.mtx.Lock()
+	defer rr.mtx.Unlock()
+
+	rr.requests++
+	if len(rr.statuses) > 0 {
+		status := rr.statuses[0]
+		rr.statuses = rr.statuses[1:]
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	compressed, _ := io.ReadAll(r.Body)
+	body, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	for _, ts := range decodeFields(body, 1) {
+		var key string
+		for _, l := range decodeFields(ts, 1) {
+			key += string(decodeFields(l, 1)[0]) + "=" + string(decodeFields(l, 2)[0]) + ","
+		}
+		sample := decodeFields(ts, 2)[0]
+		v, _ := protowire.ConsumeFixed64(sample[1:])
+		rr.series[key] = math.Float64frombits(v)
+	}
+}
+
+// decodeFields returns the raw values of all length-delimited or fixed64 fields with the given number
+func decodeFields(b []byte, field protowire.Number) [][]byte {
+	var out [][]byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		tag := b[:n]
+		b = b[n:]
+		switch typ {
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if num == field {
+				out = append(out, v)
+			}
+			b = b[n:]
+		default:
+			n := protowire.ConsumeFieldValue(num, typ, b)
+			if num == field {
+				out = append(out, append(append([]byte{}, tag...), b[:n]...))
+			}
+			b = b[n:]
+		}
+	}
+	return out
+}
+
+func TestRemoteWriterPush(t *testing.T) {
+	receiver := &remoteWriteReceiver{series: map[string]float64{}}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	p.reqDur.WithLabelValues("200", "GET_/").Observe(0.25)
+
+	w, err := p.NewRemoteWriter(RemoteWriteConfig{
+		URL:            srv.URL,
+		ExternalLabels: map[string]string{"instance": "edge-1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	for key, want := range map[string]float64{
+		"__name__=gin_request_duration_seconds_bucket,code=200,instance=edge-1,le=0.3,path=GET_/,": 1,
+		"__name__=gin_request_duration_seconds_bucket,code=200,instance=edge-1,le=0.2,path=GET_/,": 0,
+		"__name__=gin_request_duration_seconds_sum,code=200,instance=edge-1,path=GET_/,":           0.25,
+		"__name__=gin_request_duration_seconds_count,code=200,instance=edge-1,path=GET_/,":         1,
+	} {
+		got, ok := receiver.series[key]
+		if !ok {
+			t.Errorf("series %s not received", key)
+		} else if got != want {
+			t.Errorf("series %s = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestRemoteWriterRetries(t *testing.T) {
+	receiver := &remoteWriteReceiver{
+		series:   map[string]float64{},
+		statuses: []int{http.StatusServiceUnavailable, http.StatusOK},
+	}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	w, err := p.NewRemoteWriter(RemoteWriteConfig{URL: srv.URL, MinBackoff: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Push(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if receiver.requests != 2 {
+		t.Errorf("expected 2 requests, got %d", receiver.requests)
+	}
+	if got := testutil.ToFloat64(w.retries); got != 1 {
+		t.Errorf("expected 1 retry, got %v", got)
+	}
+}
+
+func TestRemoteWriterDropsSamples(t *testing.T) {
+	receiver := &remoteWriteReceiver{
+		series:   map[string]float64{},
+		statuses: []int{http.StatusBadRequest},
+	}
+	srv := httptest.NewServer(receiver)
+	defer srv.Close()
+
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	reg.MustRegister(prometheus.NewCounter(prometheus.CounterOpts{Name: "extra_total", Help: "extra"}))
+
+	w, err := p.NewRemoteWriter(RemoteWriteConfig{URL: srv.URL, QueueCapacity: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Push(context.Background()); err == nil {
+		t.Fatal("expected error on rejected batch")
+	}
+
+	if got := testutil.ToFloat64(w.droppedSamples.WithLabelValues("queue_full")); got == 0 {
+		t.Error("expected samples dropped because of the queue capacity")
+	}
+	if got := testutil.ToFloat64(w.droppedSamples.WithLabelValues("rejected")); got != 1 {
+		t.Errorf("expected 1 rejected sample, got %v", got)
+	}
+	if got := testutil.ToFloat64(w.pendingSamples); got != 0 {
+		t.Errorf("expected empty queue, got %v", got)
+	}
+}
@@ -0,0 +1,115 @@
+package gpmiddleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// routeLabelNames are the labels added to every route-scoped metric, before the custom ones
+var routeLabelNames = []string{"method", "route"}
+
+// RouteCounter is a counter declared by the application, labeled by the route of the request
+type RouteCounter struct {
+	p   *Prometheus
+	vec *counterVec
+}
+
+// RouteHistogram is a histogram declared by the application, labeled by the route of the request
+type RouteHistogram struct {
+	p   *Prometheus
+	vec *histogramVec
+}
+
+// RouteGauge is a gauge declared by the application, labeled by the route of the request
+type RouteGauge struct {
+	p   *Prometheus
+	vec *gaugeVec
+}
+
+// NewRouteCounter declares a counter in the registry and subsystem of the middleware. Its values
+// are labeled by the "method" and "route" of the request, followed by the given labels.
+func (p *Prometheus) NewRouteCounter(name, help string, labels ...string) (*RouteCounter, error) {
+	vec := newCounterVec(prometheus.CounterOpts{
+		Subsystem: p.subsystem,
+		Name:      name,
+		Help:      help,
+	}, routeLabels(labels))
+	if err := p.register(vec); err != nil {
+		return nil, err
+	}
+	return &RouteCounter{p: p, vec: vec}, nil
+}
+
+// NewRouteHistogram declares a histogram in the registry and subsystem of the middleware, see
+// NewRouteCounter. Buckets default to the ones of request_duration_seconds.
+func (p *Prometheus) NewRouteHistogram(name, help string, buckets []float64, labels ...string) (*RouteHistogram, error) {
+	if len(buckets) == 0 {
+		buckets = defaultDurationBuckets
+	}
+	vec := newHistogramVec(prometheus.HistogramOpts{
+		Subsystem: p.subsystem,
+		Name:      name,
+		Help:      help,
+		Buckets:   buckets,
+	}, routeLabels(labels))
+	if err := p.register(vec); err != nil {
+		return nil, err
+	}
+	return &RouteHistogram{p: p, vec: vec}, nil
+}
+
+// NewRouteGauge declares a gauge in the registry and subsystem of the middleware, see NewRouteCounter
+func (p *Prometheus) NewRouteGauge(name, help string, labels ...string) (*RouteGauge, error) {
+	vec := newGaugeVec(prometheus.GaugeOpts{
+		Subsystem: p.subsystem,
+		Name:      name,
+		Help:      help,
+	}, routeLabels(labels))
+	if err := p.register(vec); err != nil {
+		return nil, err
+	}
+	return &RouteGauge{p: p, vec: vec}, nil
+}
+
+func routeLabels(labels []string) []string {
+	return append(append([]string{}, routeLabelNames...), labels...)
+}
+
+func (p *Prometheus) routeLabelValues(c *gin.Context, values []string) []string {
+	return append([]string{c.Request.Method, p.routeLabel(c)}, values...)
+}
+
+// Inc increments the counter for the route of c
+func (rc *RouteCounter) Inc(c *gin.Context, values ...string) {
+	rc.Add(c, 1, values...)
+}
+
+// Add adds v to the counter for the route of c
+func (rc *RouteCounter) Add(c *gin.Context, v float64, values ...string) {
+	rc.p.count(rc.vec, v, rc.p.routeLabelValues(c, values)...)
+}
+
+// Observe records v in the histogram for the route of c
+func (rh *RouteHistogram) Observe(c *gin.Context, v float64, values ...string) {
+	rh.p.observe(rh.vec, v, rh.p.routeLabelValues(c, values)...)
+}
+
+// Set sets the gauge for the route of c
+func (rg *RouteGauge) Set(c *gin.Context, v float64, values ...string) {
+	rg.p.setGauge(rg.vec, v, rg.p.routeLabelValues(c, values)...)
+}
+
+// Add adds v to the gauge for the route of c
+func (rg *RouteGauge) Add(c *gin.Context, v float64, values ...string) {
+	rg.p.addGauge(rg.vec, v, rg.p.routeLabelValues(c, values)...)
+}
+
+// Inc increments the gauge for the route of c
+func (rg *RouteGauge) Inc(c *gin.Context, values ...string) {
+	rg.Add(c, 1, values...)
+}
+
+// Dec decrements the gauge for the route of c
+func (rg *RouteGauge) Dec(c *gin.Context, values ...string) {
+	rg.Add(c, -1, values...)
+}
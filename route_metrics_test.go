@@ -0,0 +1,89 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRouteMetrics(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+
+	orders, err := p.NewRouteCounter("orders_created_total", "Orders created", "country")
+	if err != nil {
+		t.Fatal(err)
+	}
+	amount, err := p.NewRouteHistogram("order_amount", "Order amounts", []float64{10, 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	carts, err := p.NewRouteGauge("open_carts", "Open carts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.NewRouteCounter("orders_created_total", "Orders created"); err == nil {
+		t.Error("expected an error on duplicated metric")
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.POST("/orders/:id", func(c *gin.Context) {
+		orders.Inc(c, "sg")
+		amount.Observe(c, 42)
+		carts.Inc(c)
+		carts.Inc(c)
+		carts.Dec(c)
+		c.Status(http.StatusCreated)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders/1", nil))
+
+	expected := `
+# HELP gin_open_carts Open carts
+# TYPE gin_open_carts gauge
+gin_open_carts{method="POST",route="/orders/:id"} 1
+# HELP gin_order_amount Order amounts
+# TYPE gin_order_amount histogram
+gin_order_amount_bucket{method="POST",route="/orders/:id",le="10"} 0
+gin_order_amount_bucket{method="POST",route="/orders/:id",le="100"} 1
+gin_order_amount_bucket{method="POST",route="/orders/:id",le="+Inf"} 1
+gin_order_amount_sum{method="POST",route="/orders/:id"} 42
+gin_order_amount_count{method="POST",route="/orders/:id"} 1
+# HELP gin_orders_created_total Orders created
+# TYPE gin_orders_created_total counter
+gin_orders_created_total{country="sg",method="POST",route="/orders/:id"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "gin_open_carts", "gin_order_amount", "gin_orders_created_total"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRouteMetricsURLLabelMapping(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	p.ReqCntURLLabelMappingFn = func(c *gin.Context) string { return "orders" }
+
+	orders, err := p.NewRouteCounter("orders_created_total", "Orders created")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.POST("/orders/:id", func(c *gin.Context) { orders.Inc(c) })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/orders/1", nil))
+
+	if got := testutil.ToFloat64(orders.vec.WithLabelValues("POST", "orders")); got != 1 {
+		t.Errorf("expected the mapped route label, got %v", got)
+	}
+	if n := histogramCount(t, reg, "gin_request_duration_seconds", map[string]string{"path": "POST_orders"}); n != 1 {
+		t.Errorf("expected the mapped route in request_duration_seconds, got %d", n)
+	}
+}
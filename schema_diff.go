@@ -0,0 +1,203 @@
+package gpmiddleware
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// SchemaChangeKind is the kind of a difference between two metric schemas
+type SchemaChangeKind string
+
+const (
+	// MetricRemoved is a breaking change, unless the metric is missing from a capture, where it may have no
+	// series yet
+	MetricRemoved SchemaChangeKind = "metric_removed"
+	// MetricAdded is not a breaking change
+	MetricAdded SchemaChangeKind = "metric_added"
+	// TypeChanged is a breaking change
+	TypeChanged SchemaChangeKind = "type_changed"
+	// LabelRemoved is a breaking change
+	LabelRemoved SchemaChangeKind = "label_removed"
+	// LabelAdded is not a breaking change, although it splits the existing series
+	LabelAdded SchemaChangeKind = "label_added"
+	// BucketsChanged is a breaking change
+	BucketsChanged SchemaChangeKind = "buckets_changed"
+	// LabelValuesRemoved is not a breaking change, as values come and go with the routes, but it may break
+	// the queries selecting them
+	LabelValuesRemoved SchemaChangeKind = "label_values_removed"
+)
+
+// SchemaChange is a difference between two metric schemas
+type SchemaChange struct {
+	Metric   string           `json:"metric"`
+	Kind     SchemaChangeKind `json:"kind"`
+	Detail   string           `json:"detail,omitempty"`
+	Breaking bool             `json:"breaking"`
+}
+
+func (c SchemaChange) String() string {
+	severity := "info"
+	if c.Breaking {
+		severity = "BREAKING"
+	}
+	if c.Detail == "" {
+		return fmt.Sprintf("%s %s: %s", severity, c.Metric, c.Kind)
+	}
+	return fmt.Sprintf("%s %s: %s %s", severity, c.Metric, c.Kind, c.Detail)
+}
+
+// CompareMetricSchemas returns the changes from the schema from to the schema to, sorted by metric. Metrics
+// missing from a captured schema are reported as removed without breaking, as a capture lacks the metrics
+// without series, such as the ones of routes not requested yet.
+func CompareMetricSchemas(from, to *MetricSchema) []SchemaChange {
+	newMetrics := make(map[string]MetricDescription, len(to.Metrics))
+	for _, m := range to.Metrics {
+		newMetrics[m.Name] = m
+	}
+
+	var changes []SchemaChange
+	seen := map[string]bool{}
+	for _, o := range from.Metrics {
+		seen[o.Name] = true
+		n, ok := newMetrics[o.Name]
+		if !ok {
+			removed := SchemaChange{Metric: o.Name, Kind: MetricRemoved, Breaking: !to.Captured}
+			if to.Captured {
+				removed.Detail = "from the capture, possibly for lack of series"
+			}
+			changes = append(changes, removed)
+			continue
+		}
+		changes = append(changes, compareMetrics(o, n)...)
+	}
+	for _, n := range to.Metrics {
+		if !seen[n.Name] {
+			changes = append(changes, SchemaChange{Metric: n.Name, Kind: MetricAdded})
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool { return changes[i].Metric < changes[j].Metric })
+	return changes
+}
+
+func compareMetrics(o, n MetricDescription) []SchemaChange {
+	if o.Type != n.Type {
+		detail := fmt.Sprintf("from %s to %s", o.Type, n.Type)
+		return []SchemaChange{{Metric: o.Name, Kind: TypeChanged, Detail: detail, Breaking: true}}
+	}
+
+	var changes []SchemaChange
+	newLabels := make(map[string]MetricLabel, len(n.Labels))
+	for _, l := range n.Labels {
+		newLabels[l.Name] = l
+	}
+	oldLabels := map[string]bool{}
+	for _, l := range o.Labels {
+		oldLabels[l.Name] = true
+		nl, ok := newLabels[l.Name]
+		if !ok {
+			changes = append(changes, SchemaChange{Metric: o.Name, Kind: LabelRemoved, Detail: l.Name, Breaking: true})
+			continue
+		}
+		if len(l.Values) == 0 || len(nl.Values) == 0 {
+			continue // unknown on either side
+		}
+		var removed []string
+		for _, v := range l.Values {
+			if !slices.Contains(nl.Values, v) {
+				removed = append(removed, v)
+			}
+		}
+		if len(removed) > 0 {
+			detail := fmt.Sprintf("%s=%s", l.Name, strings.Join(removed, ","))
+			changes = append(changes, SchemaChange{Metric: o.Name, Kind: LabelValuesRemoved, Detail: detail})
+		}
+	}
+	for _, l := range n.Labels {
+		if !oldLabels[l.Name] {
+			changes = append(changes, SchemaChange{Metric: o.Name, Kind: LabelAdded, Detail: l.Name})
+		}
+	}
+
+	if len(o.Buckets) > 0 && len(n.Buckets) > 0 && !slices.Equal(o.Buckets, n.Buckets) {
+		detail := fmt.Sprintf("from %v to %v", o.Buckets, n.Buckets)
+		changes = append(changes, SchemaChange{Metric: o.Name, Kind: BucketsChanged, Detail: detail, Breaking: true})
+	}
+	return changes
+}
+
+// LoadMetricSchema reads a schema written by ExportMetricSchema, or builds it from a capture of a
+// metrics endpoint in the text format. Label values are unknown in the latter.
+func LoadMetricSchema(r io.Reader) (*MetricSchema, error) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.ContainsAny(b, " \t\r\n") {
+			if b[0] == '{' {
+				return ReadMetricSchema(br)
+			}
+			return ParseMetricsText(br)
+		}
+		br.ReadByte()
+	}
+}
+
+// ParseMetricsText builds a schema from a capture of a metrics endpoint in the text format. It lacks the
+// metrics without series, e.g. vectors not updated yet, whose removal is then not reported as breaking.
+func ParseMetricsText(r io.Reader) (*MetricSchema, error) {
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(r)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := &MetricSchema{Version: MetricSchemaVersion, Captured: true}
+	for name, mf := range families {
+		m := MetricDescription{
+			Name: name,
+			Type: strings.ToLower(mf.GetType().String()),
+			Help: mf.GetHelp(),
+		}
+		seen := map[string]bool{}
+		for _, metric := range mf.GetMetric() {
+			for _, lp := range metric.GetLabel() {
+				if !seen[lp.GetName()] {
+					seen[lp.GetName()] = true
+					m.Labels = append(m.Labels, MetricLabel{Name: lp.GetName()})
+				}
+			}
+			if h := metric.GetHistogram(); h != nil && m.Buckets == nil {
+				for _, b := range h.GetBucket() {
+					if !math.IsInf(b.GetUpperBound(), 1) {
+						m.Buckets = append(m.Buckets, b.GetUpperBound())
+					}
+				}
+			}
+		}
+		schema.Metrics = append(schema.Metrics, m)
+	}
+
+	sort.Slice(schema.Metrics, func(i, j int) bool { return schema.Metrics[i].Name < schema.Metrics[j].Name })
+	return schema, nil
+}
+
+// HasBreakingChanges reports whether any of the changes is breaking
+func HasBreakingChanges(changes []SchemaChange) bool {
+	for _, c := range changes {
+		if c.Breaking {
+			return true
+		}
+	}
+	return false
+}
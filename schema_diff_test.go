@@ -0,0 +1,98 @@
+package gpmiddleware
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompareMetricSchemas(t *testing.T) {
+	from := &MetricSchema{Metrics: []MetricDescription{
+		{Name: "a_total", Type: "counter", Labels: []MetricLabel{{Name: "path"}, {Name: "code"}}},
+		{Name: "b_seconds", Type: "histogram", Labels: []MetricLabel{{Name: "path"}}, Buckets: []float64{0.1, 1}},
+		{Name: "c", Type: "gauge"},
+		{Name: "d_total", Type: "counter", Labels: []MetricLabel{{Name: "zone", Values: []string{"x", "y"}}}},
+	}}
+	to := &MetricSchema{Metrics: []MetricDescription{
+		{Name: "a_total", Type: "counter", Labels: []MetricLabel{{Name: "route"}, {Name: "code"}}},
+		{Name: "b_seconds", Type: "histogram", Labels: []MetricLabel{{Name: "path"}}, Buckets: []float64{0.1, 0.5, 1}},
+		{Name: "d_total", Type: "gauge"},
+		{Name: "e", Type: "gauge"},
+	}}
+
+	expected := []SchemaChange{
+		{Metric: "a_total", Kind: LabelRemoved, Detail: "path", Breaking: true},
+		{Metric: "a_total", Kind: LabelAdded, Detail: "route"},
+		{Metric: "b_seconds", Kind: BucketsChanged, Detail: "from [0.1 1] to [0.1 0.5 1]", Breaking: true},
+		{Metric: "c", Kind: MetricRemoved, Breaking: true},
+		{Metric: "d_total", Kind: TypeChanged, Detail: "from counter to gauge", Breaking: true},
+		{Metric: "e", Kind: MetricAdded},
+	}
+	changes := CompareMetricSchemas(from, to)
+	if !reflect.DeepEqual(changes, expected) {
+		t.Errorf("expected %v, got %v", expected, changes)
+	}
+	if !HasBreakingChanges(changes) {
+		t.Error("expected breaking changes")
+	}
+
+	to.Metrics[2] = MetricDescription{Name: "d_total", Type: "counter", Labels: []MetricLabel{{Name: "zone", Values: []string{"y", "z"}}}}
+	changes = CompareMetricSchemas(from, to)
+	if c := changes[len(changes)-2]; c.Kind != LabelValuesRemoved || c.Detail != "zone=x" || c.Breaking {
+		t.Errorf("unexpected change %v", c)
+	}
+	if changes := CompareMetricSchemas(from, from); len(changes) != 0 {
+		t.Errorf("expected no changes, got %v", changes)
+	}
+
+	// a capture lacks the metrics without series
+	to.Captured = true
+	for _, c := range CompareMetricSchemas(from, to) {
+		if c.Kind == MetricRemoved && c.Breaking {
+			t.Errorf("expected a metric missing from a capture not to break, got %v", c)
+		}
+	}
+}
+
+func TestLoadMetricSchema(t *testing.T) {
+	capture := `
+# HELP gin_request_duration_seconds Histogram request latencies
+# TYPE gin_request_duration_seconds histogram
+gin_request_duration_seconds_bucket{code="200",path="GET_/",le="0.1"} 1
+gin_request_duration_seconds_bucket{code="200",path="GET_/",le="1"} 1
+gin_request_duration_seconds_bucket{code="200",path="GET_/",le="+Inf"} 1
+gin_request_duration_seconds_sum{code="200",path="GET_/"} 0.01
+gin_request_duration_seconds_count{code="200",path="GET_/"} 1
+# TYPE up gauge
+up 1
+`
+	schema, err := LoadMetricSchema(strings.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []MetricDescription{
+		{
+			Name:    "gin_request_duration_seconds",
+			Type:    "histogram",
+			Help:    "Histogram request latencies",
+			Labels:  []MetricLabel{{Name: "code"}, {Name: "path"}},
+			Buckets: []float64{0.1, 1},
+		},
+		{Name: "up", Type: "gauge"},
+	}
+	if !reflect.DeepEqual(schema.Metrics, expected) {
+		t.Errorf("expected %+v, got %+v", expected, schema.Metrics)
+	}
+	if !schema.Captured {
+		t.Error("expected the schema to be marked as captured")
+	}
+
+	schema, err = LoadMetricSchema(strings.NewReader(`
+	{"version": 1, "metrics": [{"name": "up", "type": "gauge", "help": "", "labels": []}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(schema.Metrics) != 1 || schema.Metrics[0].Name != "up" || schema.Captured {
+		t.Errorf("unexpected schema %+v", schema)
+	}
+}
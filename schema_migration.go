@@ -0,0 +1,138 @@
+package gpmiddleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Schema of the request duration metric
+type Schema string
+
+const (
+	// SchemaLegacy is request_duration_seconds labeled by "code" and "path", the path being made of the
+	// method and the route, e.g. GET_/items/:id
+	SchemaLegacy Schema = "legacy"
+	// SchemaV2 is http_request_duration_seconds labeled by "method", "route" and "code"
+	SchemaV2 Schema = "v2"
+)
+
+// SetSchemas selects the schemas of the request duration metric, the legacy one only by default.
+// Emitting both during a transition lets dashboards and alerts migrate to the new one, scrapes selecting
+// a single schema with the "schema" query parameter of the metrics endpoint, e.g. /metrics?schema=v2.
+// Scrapes including the legacy schema are counted in legacy_scrapes_total, to know when it can be
+// disabled. It must be called before the middleware is installed.
+func (p *Prometheus) SetSchemas(schemas ...Schema) error {
+	enabled := map[Schema]bool{}
+	for _, s := range schemas {
+		if s != SchemaLegacy && s != SchemaV2 {
+			return fmt.Errorf("unknown schema %q", s)
+		}
+		enabled[s] = true
+	}
+	if len(enabled) == 0 {
+		return errors.New("at least one schema is required")
+	}
+
+	if enabled[SchemaV2] && p.reqDurV2 == nil {
+		reqDurV2 := newHistogramVec(
+			prometheus.HistogramOpts{
+				Subsystem: p.subsystem,
+				Name:      "http_request_duration_seconds",
+				Help:      "Histogram request latencies",
+				Buckets:   defaultDurationBuckets,
+			},
+			[]string{"method", "route", "code"},
+		)
+		legacyScrapes := newCounterVec(prometheus.CounterOpts{
+			Subsystem: p.subsystem,
+			Name:      "legacy_scrapes_total",
+			Help:      "Scrapes of the metrics endpoint including the legacy schema of the request metrics",
+		}, nil)
+		legacyScrapes.WithLabelValues() // exposed from 0, for the lack of legacy scrapes to be seen
+		if err := p.registerAll(p.register, reqDurV2, legacyScrapes); err != nil {
+			return err
+		}
+		p.reqDurV2, p.legacyScrapes = reqDurV2, legacyScrapes
+	} else if !enabled[SchemaV2] && p.reqDurV2 != nil {
+		p.unregister(p.reqDurV2)
+		p.unregister(p.legacyScrapes)
+		p.reqDurV2, p.legacyScrapes = nil, nil
+	}
+
+	if enabled[SchemaLegacy] != p.emits(SchemaLegacy) {
+		if enabled[SchemaLegacy] {
+			if err := p.register(p.reqDur); err != nil {
+				return err
+			}
+		} else {
+			p.unregister(p.reqDur)
+		}
+	}
+	p.schemas = enabled
+	return nil
+}
+
+// emits reports whether the request duration metric is emitted in the schema
+func (p *Prometheus) emits(s Schema) bool {
+	if p.schemas == nil {
+		return s == SchemaLegacy
+	}
+	return p.schemas[s]
+}
+
+// observeDuration records the duration of the request in the enabled schemas
+func (p *Prometheus) observeDuration(c *gin.Context, elapsed float64, code, path string) {
+	if p.emits(SchemaLegacy) {
+		p.observe(p.reqDur, elapsed, code, path)
+	}
+	if p.emits(SchemaV2) {
+		p.observe(p.reqDurV2, elapsed, c.Request.Method, p.routeLabel(c), code)
+	}
+}
+
+// excludingGatherer gathers the metrics of a gatherer but the one with the excluded name
+type excludingGatherer struct {
+	prometheus.Gatherer
+	excluded string
+}
+
+func (g excludingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := g.Gatherer.Gather()
+	out := mfs[:0]
+	for _, mf := range mfs {
+		if mf.GetName() != g.excluded {
+			out = append(out, mf)
+		}
+	}
+	return out, err
+}
+
+// serveSchemas serves the metrics in the schema selected by the scrape, counting the ones of the legacy schema
+func (p *Prometheus) serveSchemas(c *gin.Context, h http.Handler) {
+	schema := Schema(c.Query("schema"))
+	if !p.emits(schema) {
+		schema = "" // not enabled, all the enabled schemas are served
+	}
+	if p.emits(SchemaLegacy) && schema != SchemaV2 {
+		p.count(p.legacyScrapes, 1)
+	}
+
+	var excluded string
+	switch {
+	case schema == SchemaLegacy && p.emits(SchemaV2):
+		excluded = p.reqDurV2.name
+	case schema == SchemaV2 && p.emits(SchemaLegacy):
+		excluded = p.reqDur.name
+	default:
+		h.ServeHTTP(c.Writer, c.Request)
+		return
+	}
+	g := excludingGatherer{Gatherer: p.gatherer, excluded: excluded}
+	promhttp.HandlerFor(g, promhttp.HandlerOpts{}).ServeHTTP(c.Writer, c.Request)
+}
@@ -0,0 +1,126 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSchemaMigration(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	if err := p.SetSchemas(SchemaLegacy, SchemaV2); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/items/:id", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/items/1", nil))
+
+	if n := histogramCount(t, reg, "gin_request_duration_seconds", map[string]string{"code": "200", "path": "GET_/items/:id"}); n != 1 {
+		t.Errorf("expected 1 legacy duration, got %d", n)
+	}
+	labels := map[string]string{"code": "200", "method": "GET", "route": "/items/:id"}
+	if n := histogramCount(t, reg, "gin_http_request_duration_seconds", labels); n != 1 {
+		t.Errorf("expected 1 v2 duration, got %d", n)
+	}
+
+	scrape := func(query string) string {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics"+query, nil))
+		return w.Body.String()
+	}
+	for _, tc := range []struct {
+		query         string
+		legacy, v2    bool
+		legacyScrapes float64
+	}{
+		{"", true, true, 1},
+		{"?schema=legacy", true, false, 2},
+		{"?schema=v2", false, true, 2},
+	} {
+		body := scrape(tc.query)
+		if strings.Contains(body, "gin_request_duration_seconds_count") != tc.legacy {
+			t.Errorf("%q: expected legacy schema %v", tc.query, tc.legacy)
+		}
+		if strings.Contains(body, "gin_http_request_duration_seconds_count") != tc.v2 {
+			t.Errorf("%q: expected v2 schema %v", tc.query, tc.v2)
+		}
+		if n := testutil.ToFloat64(p.legacyScrapes); n != tc.legacyScrapes {
+			t.Errorf("%q: expected %v legacy scrapes, got %v", tc.query, tc.legacyScrapes, n)
+		}
+	}
+}
+
+func TestSchemaMigrationLegacyDisabled(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	if err := p.SetSchemas(SchemaV2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetSchemas("v3"); err == nil {
+		t.Error("expected an error for an unknown schema")
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/", func(c *gin.Context) {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if series := gatherSeries(t, reg, "gin_request_duration_seconds", nil); len(series) != 0 {
+		t.Errorf("expected no legacy series, got %v", series)
+	}
+	if n := testutil.ToFloat64(p.legacyScrapes); n != 0 {
+		t.Errorf("expected no legacy scrapes, got %v", n)
+	}
+	for _, m := range p.MetricCatalog() {
+		if m.Name == "gin_request_duration_seconds" {
+			t.Error("expected the legacy schema not to be in the catalog")
+		}
+	}
+}
+
+func TestSchemaMigrationV2Disabled(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+	if err := p.SetSchemas(SchemaLegacy, SchemaV2); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetSchemas(SchemaLegacy); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/", func(c *gin.Context) {})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics?schema=v2", nil))
+	body := w.Body.String()
+	if !strings.Contains(body, "gin_request_duration_seconds_count") {
+		t.Error("expected the legacy schema to be served when v2 is not enabled")
+	}
+	if strings.Contains(body, "gin_http_request_duration_seconds") || strings.Contains(body, "gin_legacy_scrapes_total") {
+		t.Error("expected the v2 metrics to be unregistered")
+	}
+	for _, m := range p.MetricCatalog() {
+		if m.Name == "gin_http_request_duration_seconds" || m.Name == "gin_legacy_scrapes_total" {
+			t.Errorf("expected %s not to be in the catalog", m.Name)
+		}
+	}
+
+	if err := p.SetSchemas(SchemaV2); err != nil {
+		t.Fatalf("expected v2 to be enabled again, got %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package gpmiddleware
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	defaultEstimatedStatusCodes = []string{"200", "400", "404", "500"}
+	defaultBytesPerSeries       = 3 * 1024
+)
+
+// SeriesEstimateConfig configures the estimate of the series of the middleware metrics
+type SeriesEstimateConfig struct {
+	// Routes served, defaults to the ones of the engine the middleware is installed on
+	Routes gin.RoutesInfo
+	// StatusCodes returned, defaults to 200, 400, 404 and 500
+	StatusCodes []string
+	// Buckets of the histograms, defaults to their configured buckets
+	Buckets int
+	// Labels are the allowed values of labels, overriding the known ones
+	Labels map[string][]string
+	// AddedLabels are labels planned to be added to metrics, keyed by metric name, their values being the
+	// ones of Labels
+	AddedLabels map[string][]string
+	// Budget of series above which the estimate warns. No budget when 0
+	Budget int
+	// BytesPerSeries is the memory taken by a series, defaults to 3KiB, about what a series costs in the
+	// head block of a Prometheus server
+	BytesPerSeries int
+}
+
+// MetricSeriesEstimate is the estimated number of series of a metric
+type MetricSeriesEstimate struct {
+	Name   string `json:"name"`
+	Series int    `json:"series"`
+}
+
+// SeriesEstimate is the estimated number of series of the middleware metrics, and their memory
+type SeriesEstimate struct {
+	Metrics     []MetricSeriesEstimate `json:"metrics"`
+	Series      int                    `json:"series"`
+	MemoryBytes int                    `json:"memory_bytes"`
+	OverBudget  bool                   `json:"over_budget"`
+	Warnings    []string               `json:"warnings,omitempty"`
+}
+
+// EstimateSeries estimates the series of the metrics registered by the middleware, the labels taking all the
+// combinations of their values. It is an upper bound, as not every route returns every status code, except
+// for the labels whose values are unknown, counted as taking a single value with a warning, and for the
+// routes set with SetRoute by requests not served yet. Only the metrics which can be emitted are counted:
+// the ones of the enabled features, request_phase_duration_seconds once a phase was recorded and
+// middleware_duration_seconds once a handler was instrumented.
+func (p *Prometheus) EstimateSeries(cfg SeriesEstimateConfig) SeriesEstimate {
+	if cfg.Routes == nil && p.engine != nil {
+		cfg.Routes = p.engine.Routes()
+	}
+	if len(cfg.StatusCodes) == 0 {
+		cfg.StatusCodes = defaultEstimatedStatusCodes
+	}
+	if cfg.BytesPerSeries <= 0 {
+		cfg.BytesPerSeries = defaultBytesPerSeries
+	}
+
+	var est SeriesEstimate
+	for _, m := range p.MetricCatalog() {
+		labels := append([]MetricLabel{}, m.Labels...)
+		for _, l := range cfg.AddedLabels[m.Name] {
+			labels = append(labels, MetricLabel{Name: l})
+		}
+
+		series := 1
+		for _, l := range labels {
+			n := p.estimatedValues(cfg, l)
+			if n == 0 {
+				warning := fmt.Sprintf("unknown values of label %q of %s, counted as 1", l.Name, m.Name)
+				est.Warnings = append(est.Warnings, warning)
+				n = 1
+			}
+			series *= n
+		}
+		if m.Type == "histogram" {
+			buckets := len(m.Buckets)
+			if cfg.Buckets > 0 {
+				buckets = cfg.Buckets
+			}
+			series *= buckets + 3 // +Inf, _sum and _count
+		}
+
+		est.Metrics = append(est.Metrics, MetricSeriesEstimate{Name: m.Name, Series: series})
+		est.Series += series
+	}
+	sort.Slice(est.Metrics, func(i, j int) bool { return est.Metrics[i].Series > est.Metrics[j].Series })
+
+	est.MemoryBytes = est.Series * cfg.BytesPerSeries
+	if cfg.Budget > 0 && est.Series > cfg.Budget {
+		est.OverBudget = true
+		est.Warnings = append(est.Warnings, fmt.Sprintf("%d series exceed the budget of %d", est.Series, cfg.Budget))
+	}
+	return est
+}
+
+// estimatedValues returns the number of values of the label, 0 if unknown
+func (p *Prometheus) estimatedValues(cfg SeriesEstimateConfig, l MetricLabel) int {
+	if values, ok := cfg.Labels[l.Name]; ok {
+		return len(values)
+	}
+	switch l.Name {
+	case "code":
+		return len(cfg.StatusCodes)
+	case "path", "method", "route":
+		if cfg.Routes != nil {
+			return len(p.knownRouteValues(cfg.Routes, l.Name))
+		}
+	}
+	return len(l.Values)
+}
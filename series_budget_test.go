@@ -0,0 +1,130 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestEstimateSeries(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	if err := p.SetOutcomeLabels("tenant"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetApdex(ApdexConfig{}); err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/items", func(c *gin.Context) {})
+	r.POST("/items", func(c *gin.Context) {})
+	r.GET("/items/:id", func(c *gin.Context) {})
+
+	est := p.EstimateSeries(SeriesEstimateConfig{
+		StatusCodes: []string{"200", "500"},
+		Labels:      map[string][]string{"tenant": {"a", "b", "c"}},
+		AddedLabels: map[string][]string{"gin_request_duration_seconds": {"tenant"}},
+		Budget:      100,
+	})
+
+	series := map[string]int{}
+	for _, m := range est.Metrics {
+		series[m.Name] = m.Series
+	}
+	// 3 routes and the 404 of 2 methods, 2 codes, 3 tenants, 10 buckets + 3
+	if n := series["gin_request_duration_seconds"]; n != 5*2*3*13 {
+		t.Errorf("unexpected request duration series %d", n)
+	}
+	// 5 paths, 2 outcomes, 3 tenants
+	if n := series["gin_request_outcomes_total"]; n != 5*2*3 {
+		t.Errorf("unexpected outcome series %d", n)
+	}
+	if n := series["gin_apdex_requests_total"]; n != 5*3 {
+		t.Errorf("unexpected apdex series %d", n)
+	}
+	if est.Metrics[0].Name != "gin_request_duration_seconds" {
+		t.Errorf("expected the metrics sorted by series, got %v", est.Metrics)
+	}
+	if !est.OverBudget || est.MemoryBytes != est.Series*3*1024 {
+		t.Errorf("unexpected estimate %+v", est)
+	}
+
+	est = p.EstimateSeries(SeriesEstimateConfig{
+		Routes:  gin.RoutesInfo{{Method: http.MethodGet, Path: "/"}},
+		Buckets: 5,
+	})
+	for _, m := range est.Metrics {
+		if m.Name == "gin_request_duration_seconds" && m.Series != 2*4*8 {
+			t.Errorf("unexpected request duration series %d", m.Series)
+		}
+	}
+	if est.OverBudget || len(est.Warnings) == 0 {
+		t.Errorf("expected warnings about the unknown values of the middleware labels, got %+v", est)
+	}
+}
+
+func TestEstimateSeriesDefault(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	p := NewPrometheusWithRegistry("gin", reg)
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	for i := 0; i < 10; i++ {
+		path := "/items/" + strconv.Itoa(i)
+		r.GET(path, func(c *gin.Context) {
+			if c.Query("fail") != "" {
+				c.Status(http.StatusNotFound)
+				SetOutcome(c, OutcomeFailure)
+			}
+		})
+	}
+	for _, route := range r.Routes() {
+		if route.Path == p.MetricsPath {
+			continue
+		}
+		for _, query := range []string{"", "?fail=1"} {
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, route.Path+query, nil))
+		}
+	}
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	est := p.EstimateSeries(SeriesEstimateConfig{StatusCodes: []string{"200", "404"}})
+	if len(est.Warnings) != 0 {
+		t.Errorf("unexpected warnings %v", est.Warnings)
+	}
+
+	mfs, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := map[string]int{}
+	for _, mf := range mfs {
+		for _, m := range mf.GetMetric() {
+			if h := m.GetHistogram(); h != nil {
+				actual[mf.GetName()] += len(h.GetBucket()) + 3 // +Inf, _sum and _count
+			} else {
+				actual[mf.GetName()]++
+			}
+		}
+	}
+	for _, m := range est.Metrics {
+		if m.Series < actual[m.Name] {
+			t.Errorf("%s: estimated %d series, below the %d produced", m.Name, m.Series, actual[m.Name])
+		}
+		delete(actual, m.Name)
+	}
+	if len(actual) != 0 {
+		t.Errorf("metrics produced but not estimated %v", actual)
+	}
+	// 10 routes and the 404 of GET, 2 codes, 10 buckets + 3, and 2 outcomes
+	if est.Series != 11*2*13+11*2 {
+		t.Errorf("expected only the emitted metrics to be estimated, got %+v", est.Metrics)
+	}
+}
@@ -0,0 +1,135 @@
+package gpmiddleware
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var minSeriesExpiryInterval = time.Second
+
+// labelValuesDeleter is implemented by the metric vectors of the prometheus client
+type labelValuesDeleter interface {
+	DeleteLabelValues(lvs ...string) bool
+}
+
+type trackedSeries struct {
+	name     string
+	vec      labelValuesDeleter
+	values   []string
+	lastSeen time.Time
+}
+
+// seriesExpiry deletes the series of the middleware metrics which were not updated for a while,
+// such as the ones of removed routes or of custom label values which are not used anymore
+type seriesExpiry struct {
+	ttl    time.Duration
+	active *gaugeVec
+
+	mtx    sync.Mutex
+	series map[string]*trackedSeries
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// SetSeriesTTL deletes the series of the middleware metrics after ttl without being updated. Expired
+// series are checked in the background until Close is called. Only counters and histograms are expired:
+// gauges such as connections or RouteGauge ones hold a state, which an expiry would reset. In multiprocess
+// mode, only the series of the current process are expired, not the ones written to the shared files.
+func (p *Prometheus) SetSeriesTTL(ttl time.Duration) error {
+	e := &seriesExpiry{
+		ttl: ttl,
+		active: newGaugeVec(prometheus.GaugeOpts{
+			Subsystem: p.subsystem,
+			Name:      "active_series",
+			Help:      "Series of the middleware metrics updated within their TTL",
+		}, []string{"metric"}),
+		series: map[string]*trackedSeries{},
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if p.expiry != nil {
+		p.unregister(p.expiry.active)
+	}
+	if err := p.registerLocal(e.active); err != nil {
+		if p.expiry != nil {
+			p.registerLocal(p.expiry.active)
+		}
+		return err
+	}
+	if p.expiry != nil {
+		p.expiry.close()
+	}
+	p.expiry = e
+
+	interval := ttl / 2
+	if interval < minSeriesExpiryInterval {
+		interval = minSeriesExpiryInterval
+	}
+	go e.run(interval)
+	return nil
+}
+
+func (e *seriesExpiry) run(interval time.Duration) {
+	defer close(e.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			e.expire(now)
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+// touch marks the series as updated now
+func (e *seriesExpiry) touch(name string, vec labelValuesDeleter, values []string) {
+	key := name + "\xff" + strings.Join(values, "\xff")
+	now := time.Now()
+
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	if s, ok := e.series[key]; ok {
+		s.lastSeen = now
+		return
+	}
+	e.series[key] = &trackedSeries{
+		name:     name,
+		vec:      vec,
+		values:   append([]string(nil), values...),
+		lastSeen: now,
+	}
+	e.active.WithLabelValues(name).Inc()
+}
+
+// expire deletes the series not updated since ttl before now
+func (e *seriesExpiry) expire(now time.Time) {
+	e.mtx.Lock()
+	defer e.mtx.Unlock()
+
+	for key, s := range e.series {
+		if now.Sub(s.lastSeen) < e.ttl {
+			continue
+		}
+		s.vec.DeleteLabelValues(s.values...)
+		delete(e.series, key)
+		e.active.WithLabelValues(s.name).Dec()
+	}
+}
+
+func (e *seriesExpiry) close() {
+	select {
+	case <-e.stop:
+	default:
+		close(e.stop)
+	}
+	<-e.done
+}
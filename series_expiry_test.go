@@ -0,0 +1,113 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSeriesExpiry(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	if err := p.SetSeriesTTL(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/old", func(c *gin.Context) { c.Status(http.StatusOK) })
+	r.GET("/new", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/old", nil))
+	if got := testutil.ToFloat64(p.expiry.active.WithLabelValues("gin_request_duration_seconds")); got != 1 {
+		t.Fatalf("expected 1 active series, got %v", got)
+	}
+
+	p.expiry.expire(time.Now().Add(30 * time.Second))
+	if n := testutil.CollectAndCount(p.reqDur); n != 1 {
+		t.Fatalf("expected the series to be kept within its TTL, got %d series", n)
+	}
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/new", nil))
+	p.expiry.mtx.Lock()
+	for _, s := range p.expiry.series {
+		for _, v := range s.values {
+			if v == "GET_/old" {
+				s.lastSeen = s.lastSeen.Add(-2 * time.Minute)
+			}
+		}
+	}
+	p.expiry.mtx.Unlock()
+	p.expiry.expire(time.Now())
+
+	if n := testutil.CollectAndCount(p.reqDur); n != 1 {
+		t.Fatalf("expected the stale series to be deleted, got %d series", n)
+	}
+	if got := testutil.ToFloat64(p.expiry.active.WithLabelValues("gin_request_duration_seconds")); got != 1 {
+		t.Errorf("expected 1 active series, got %v", got)
+	}
+	if got := testutil.ToFloat64(p.expiry.active.WithLabelValues("gin_request_outcomes_total")); got != 1 {
+		t.Errorf("expected 1 active outcome series, got %v", got)
+	}
+}
+
+func TestSeriesExpiryKeepsGauges(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	if err := p.SetSeriesTTL(time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	inFlight, err := p.NewRouteGauge("jobs_in_flight", "Jobs in flight")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/jobs", func(c *gin.Context) {
+		inFlight.Inc(c)
+		p.expiry.expire(time.Now().Add(time.Hour))
+		inFlight.Dec(c)
+		c.Status(http.StatusOK)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/jobs", nil))
+
+	if got := testutil.ToFloat64(inFlight.vec.WithLabelValues(http.MethodGet, "/jobs")); got != 0 {
+		t.Errorf("expected the gauge to be back to 0, got %v", got)
+	}
+}
+
+func TestSeriesExpiryClose(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	if err := p.SetSeriesTTL(time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSeriesExpiryRegistrationError(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gin_active_series",
+		Help: "Series of the middleware metrics updated within their TTL",
+	}, []string{"metric"}))
+	p := NewPrometheusWithRegistry("gin", reg)
+	if err := p.SetSeriesTTL(time.Minute); err == nil {
+		t.Fatal("expected the conflicting metric to fail the registration")
+	}
+	if p.expiry != nil {
+		t.Error("expected no series expiry")
+	}
+}
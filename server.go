@@ -0,0 +1,205 @@
+package gpmiddleware
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	defaultDrainPeriod     = 5 * time.Second
+	defaultShutdownTimeout = 30 * time.Second
+	defaultShutdownSignals = []os.Signal{syscall.SIGTERM, os.Interrupt}
+)
+
+// ServerConfig configures a Server
+type ServerConfig struct {
+	// Addr the gin engine listens on
+	Addr string
+	// DrainPeriod between the shutdown signal and the shutdown of the server, during which the server
+	// reports not being ready but keeps serving, for load balancers to stop sending traffic. Defaults to 5s
+	DrainPeriod time.Duration
+	// ShutdownTimeout for the in-flight requests to finish once the drain period is over. Defaults to 30s
+	ShutdownTimeout time.Duration
+	// Signals triggering the shutdown. Defaults to SIGTERM and SIGINT
+	Signals []os.Signal
+	// FinalScrapeWindow during which the metrics endpoint is still served once the server is shut down, for
+	// the shutdown metrics to be scraped, e.g. the scrape interval. It requires the metrics to be served on
+	// a separate listener set with SetListenAddress, as the one of the engine is closed by the shutdown
+	FinalScrapeWindow time.Duration
+}
+
+// Server runs a gin engine instrumented by the middleware and the metrics endpoint, and shuts them down
+// gracefully on SIGTERM
+type Server struct {
+	p       *Prometheus
+	cfg     ServerConfig
+	srv     *http.Server
+	metrics *http.Server
+
+	ready    atomic.Bool
+	inFlight atomic.Int64
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	shutdownDur      prometheus.Gauge
+	shutdownInFlight prometheus.Gauge
+}
+
+// NewServer creates a server for e, installing the middleware on it. The metrics are served on e, or on
+// the separate router when a listen address was set with SetListenAddress, which is then run by the
+// server as well: Use and UseCustom must not be called.
+func (p *Prometheus) NewServer(e *gin.Engine, cfg ServerConfig) (*Server, error) {
+	if cfg.DrainPeriod < 0 {
+		cfg.DrainPeriod = 0
+	} else if cfg.DrainPeriod == 0 {
+		cfg.DrainPeriod = defaultDrainPeriod
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		cfg.ShutdownTimeout = defaultShutdownTimeout
+	}
+	if len(cfg.Signals) == 0 {
+		cfg.Signals = defaultShutdownSignals
+	}
+	if cfg.FinalScrapeWindow > 0 && p.listenAddress == "" {
+		return nil, errors.New("a final scrape window requires a metrics listen address")
+	}
+
+	s := &Server{
+		p:    p,
+		cfg:  cfg,
+		stop: make(chan struct{}),
+	}
+	shutdownDur := newGaugeVec(prometheus.GaugeOpts{
+		Subsystem: p.subsystem,
+		Name:      "shutdown_duration_seconds",
+		Help:      "Time taken by the last shutdown of the server, from the signal to the end of in-flight requests",
+	}, nil)
+	shutdownInFlight := newGaugeVec(prometheus.GaugeOpts{
+		Subsystem: p.subsystem,
+		Name:      "shutdown_requests_in_flight",
+		Help:      "Requests in flight when the server started shutting down, after the drain period",
+	}, nil)
+	if err := p.registerAll(p.registerLocal, shutdownDur, shutdownInFlight); err != nil {
+		return nil, err
+	}
+	s.shutdownDur, s.shutdownInFlight = shutdownDur.WithLabelValues(), shutdownInFlight.WithLabelValues()
+
+	p.readyFn = s.Ready
+	p.engine = e
+	e.Use(s.countInFlight, p.HandlerFunc())
+	if p.listenAddress != "" {
+		p.router.GET(p.MetricsPath, p.prometheusHandler())
+		p.registerEndpoints(p.router)
+		s.metrics = &http.Server{Addr: p.listenAddress, Handler: p.router}
+	} else {
+		e.GET(p.MetricsPath, p.prometheusHandler())
+		p.registerEndpoints(e)
+	}
+	s.srv = &http.Server{Addr: cfg.Addr, Handler: e}
+
+	return s, nil
+}
+
+func (s *Server) countInFlight(c *gin.Context) {
+	s.inFlight.Add(1)
+	defer s.inFlight.Add(-1)
+	c.Next()
+}
+
+// HTTPServer returns the underlying server of the gin engine, e.g. to set timeouts or to instrument it
+// with InstrumentServer before running it
+func (s *Server) HTTPServer() *http.Server {
+	return s.srv
+}
+
+// Ready reports whether the server is running and not shutting down, also reported by the readiness
+// endpoint set with SetHealthChecks
+func (s *Server) Ready() bool {
+	return s.ready.Load()
+}
+
+// Run listens on the configured address and serves until a shutdown signal is received or Stop is called
+func (s *Server) Run() error {
+	l, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve serves on l until a shutdown signal is received or Stop is called. It returns once the
+// in-flight requests are done, or the shutdown timeout is over.
+func (s *Server) Serve(l net.Listener) error {
+	errs := make(chan error, 2)
+	if s.metrics != nil {
+		ml, err := s.p.listenMetrics()
+		if err != nil {
+			l.Close()
+			return err
+		}
+		go func() { errs <- s.metrics.Serve(ml) }()
+	}
+	go func() { errs <- s.srv.Serve(l) }()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, s.cfg.Signals...)
+	defer signal.Stop(signals)
+
+	s.ready.Store(true)
+	select {
+	case err := <-errs:
+		s.ready.Store(false)
+		s.srv.Close()
+		if s.metrics != nil {
+			s.metrics.Close()
+		}
+		return err
+	case <-signals:
+	case <-s.stop:
+	}
+
+	return s.shutdown()
+}
+
+// Stop triggers the shutdown of a running server, as a signal would
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+func (s *Server) shutdown() error {
+	start := time.Now()
+	s.ready.Store(false)
+	time.Sleep(s.cfg.DrainPeriod)
+
+	s.shutdownInFlight.Set(float64(s.inFlight.Load()))
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	err := s.srv.Shutdown(ctx)
+	s.shutdownDur.Set(time.Since(start).Seconds())
+
+	// the metrics are served until the end of the shutdown and the final scrape window, for them to be
+	// scraped meanwhile, including the shutdown ones
+	if s.metrics != nil {
+		time.Sleep(s.cfg.FinalScrapeWindow)
+		mctx, mcancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+		defer mcancel()
+		if merr := s.metrics.Shutdown(mctx); err == nil {
+			err = merr
+		}
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,142 @@
+package gpmiddleware
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestServerShutdown(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	s, err := p.NewServer(r, ServerConfig{DrainPeriod: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	r.GET("/slow", func(c *gin.Context) {
+		close(started)
+		time.Sleep(100 * time.Millisecond)
+		c.Status(http.StatusOK)
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+
+	resp := make(chan int)
+	go func() {
+		res, err := http.Get("http://" + l.Addr().String() + "/slow")
+		if err != nil {
+			resp <- 0
+			return
+		}
+		res.Body.Close()
+		resp <- res.StatusCode
+	}()
+	<-started
+	if !s.Ready() {
+		t.Error("expected the server to be ready")
+	}
+
+	s.Stop()
+	time.Sleep(10 * time.Millisecond)
+	if s.Ready() {
+		t.Error("expected the server not to be ready while draining")
+	}
+
+	if code := <-resp; code != http.StatusOK {
+		t.Errorf("expected the in-flight request to succeed, got %d", code)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if got := testutil.ToFloat64(s.shutdownInFlight); got != 1 {
+		t.Errorf("expected 1 request in flight at shutdown, got %v", got)
+	}
+	if got := testutil.ToFloat64(s.shutdownDur); got < 0.1 {
+		t.Errorf("expected the shutdown to last the in-flight request, got %v", got)
+	}
+}
+
+func TestServerFinalScrape(t *testing.T) {
+	ml, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metricsAddr := ml.Addr().String()
+	ml.Close()
+
+	gin.SetMode(gin.TestMode)
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	p.SetListenAddressWithRouter(metricsAddr, gin.New())
+	r := gin.New()
+	s, err := p.NewServer(r, ServerConfig{DrainPeriod: -1, FinalScrapeWindow: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+	for !s.Ready() {
+		time.Sleep(time.Millisecond)
+	}
+	s.Stop()
+
+	scrape := func() string {
+		res, err := http.Get("http://" + metricsAddr + "/metrics")
+		if err != nil {
+			return ""
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		return string(body)
+	}
+	// the shutdown gauges are exposed from 0, until set once the server is shut down
+	shutdownDur := func() float64 {
+		var parser expfmt.TextParser
+		families, err := parser.TextToMetricFamilies(strings.NewReader(scrape()))
+		if err != nil || families["gin_shutdown_duration_seconds"] == nil {
+			return 0
+		}
+		return families["gin_shutdown_duration_seconds"].GetMetric()[0].GetGauge().GetValue()
+	}
+	deadline := time.Now().Add(time.Second)
+	for shutdownDur() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if shutdownDur() == 0 {
+		t.Error("expected the shutdown duration to be scraped after the shutdown")
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if scrape() != "" {
+		t.Error("expected the metrics server to be shut down after the final scrape window")
+	}
+}
+
+func TestServerFinalScrapeWithoutListenAddress(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	if _, err := p.NewServer(gin.New(), ServerConfig{FinalScrapeWindow: time.Second}); err == nil {
+		t.Error("expected an error without a metrics listen address")
+	}
+}
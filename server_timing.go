@@ -0,0 +1,130 @@
+package gpmiddleware
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ServerTimingConfig configures the Server-Timing response header emitted by the middleware, giving
+// the total time and the request phases to the browser devtools
+type ServerTimingConfig struct {
+	// Routes, as returned by c.FullPath(), on which the header is emitted. Empty means every route
+	Routes []string
+	// Phases exposed in the header. Empty means every phase, the total is always exposed
+	Phases []string
+}
+
+type serverTiming struct {
+	routes map[string]bool
+	phases map[string]bool
+}
+
+// SetServerTiming enables the Server-Timing response header. As it exposes internals, it should be
+// restricted to routes which are not public.
+func (p *Prometheus) SetServerTiming(cfg ServerTimingConfig) {
+	st := &serverTiming{}
+	if len(cfg.Routes) > 0 {
+		st.routes = map[string]bool{}
+		for _, r := range cfg.Routes {
+			st.routes[r] = true
+		}
+	}
+	if len(cfg.Phases) > 0 {
+		st.phases = map[string]bool{}
+		for _, ph := range cfg.Phases {
+			st.phases[ph] = true
+		}
+	}
+	p.serverTiming = st
+}
+
+func (st *serverTiming) allowRoute(route string) bool {
+	return st.routes == nil || st.routes[route]
+}
+
+func (st *serverTiming) allowPhase(phase string) bool {
+	return st.phases == nil || st.phases[phase]
+}
+
+// serverTimingWriter adds the Server-Timing header right before the headers are written
+type serverTimingWriter struct {
+	gin.ResponseWriter
+	config *serverTiming
+	start  time.Time
+	phases *phaseRecorder
+	once   sync.Once
+}
+
+func (p *Prometheus) wrapServerTiming(c *gin.Context, start time.Time, phases *phaseRecorder) *serverTimingWriter {
+	if p.serverTiming == nil || !p.serverTiming.allowRoute(c.FullPath()) {
+		return nil
+	}
+	w := &serverTimingWriter{
+		ResponseWriter: c.Writer,
+		config:         p.serverTiming,
+		start:          start,
+		phases:         phases,
+	}
+	c.Writer = w
+	return w
+}
+
+func (w *serverTimingWriter) setHeader() {
+	w.once.Do(func() {
+		if w.ResponseWriter.Written() {
+			return
+		}
+
+		parts := []string{"total;dur=" + formatMillis(time.Since(w.start))}
+		for _, ph := range w.phases.snapshot() {
+			if w.config.allowPhase(ph.name) {
+				parts = append(parts, serverTimingName(ph.name)+";dur="+formatMillis(ph.duration))
+			}
+		}
+		w.Header().Add("Server-Timing", strings.Join(parts, ", "))
+	})
+}
+
+// WriteHeaderNow implements gin.ResponseWriter
+func (w *serverTimingWriter) WriteHeaderNow() {
+	w.setHeader()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// Write implements http.ResponseWriter
+func (w *serverTimingWriter) Write(data []byte) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.Write(data)
+}
+
+// WriteString implements gin.ResponseWriter
+func (w *serverTimingWriter) WriteString(s string) (int, error) {
+	w.setHeader()
+	return w.ResponseWriter.WriteString(s)
+}
+
+// Flush implements http.Flusher
+func (w *serverTimingWriter) Flush() {
+	w.setHeader()
+	w.ResponseWriter.Flush()
+}
+
+// serverTimingName turns a phase name into a valid header token, nested phases use dots
+func serverTimingName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		default:
+			return '.'
+		}
+	}, name)
+}
+
+func formatMillis(d time.Duration) string {
+	return strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
@@ -0,0 +1,59 @@
+package gpmiddleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestServerTiming(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	p.SetServerTiming(ServerTimingConfig{
+		Routes: []string{"/internal", "/empty"},
+		Phases: []string{"db", "db/query"},
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	handler := func(c *gin.Context) {
+		db := StartPhase(c, "db")
+		db.StartPhase("query").Stop()
+		db.Stop()
+		StartPhase(c, "secret").Stop()
+		c.JSON(http.StatusOK, "ok")
+	}
+	r.GET("/internal", handler)
+	r.GET("/public", handler)
+	r.GET("/empty", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+
+	for path, want := range map[string][]string{
+		"/internal": {"total;dur=", "db.query;dur=", "db;dur="},
+		"/empty":    {"total;dur="},
+		"/public":   nil,
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+		header := w.Header().Get("Server-Timing")
+		if want == nil {
+			if header != "" {
+				t.Errorf("%s: unexpected Server-Timing header %q", path, header)
+			}
+			continue
+		}
+		parts := strings.Split(header, ", ")
+		if len(parts) != len(want) {
+			t.Fatalf("%s: unexpected Server-Timing header %q", path, header)
+		}
+		for i, prefix := range want {
+			if !strings.HasPrefix(parts[i], prefix) {
+				t.Errorf("%s: expected %q to start with %q", path, parts[i], prefix)
+			}
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package gpmiddleware
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	defaultSlowRequestsSize   = 10
+	defaultSlowRequestsMaxAge = 15 * time.Minute
+	defaultSlowRequestsPath   = "/debug/slow-requests"
+	defaultRedactedHeaders    = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization", "X-Api-Key"}
+)
+
+const redacted = "[REDACTED]"
+
+// SlowRequestsConfig configures the capture of the slowest recent requests of every route
+type SlowRequestsConfig struct {
+	// Size is the number of requests kept per route. Defaults to 10
+	Size int
+	// MaxAge after which a captured request is evicted, however slow it was. Defaults to 15m
+	MaxAge time.Duration
+	// Threshold below which requests are not captured
+	Threshold time.Duration
+	// Headers captured with the requests
+	Headers []string
+	// RedactHeaders are captured with their value replaced. Defaults to Authorization, Cookie,
+	// Set-Cookie, Proxy-Authorization and X-Api-Key
+	RedactHeaders []string
+	// Path of the debug endpoint on the metrics router. Defaults to /debug/slow-requests
+	Path string
+}
+
+// SlowRequest is a captured request
+type SlowRequest struct {
+	Time     time.Time          `json:"time"`
+	Method   string             `json:"method"`
+	Path     string             `json:"path"`
+	Route    string             `json:"route"`
+	Status   int                `json:"status"`
+	Duration time.Duration      `json:"duration_ns"`
+	Headers  map[string]string  `json:"headers,omitempty"`
+	TraceID  string             `json:"trace_id,omitempty"`
+	Phases   map[string]float64 `json:"phases,omitempty"` // in seconds
+}
+
+type slowRequests struct {
+	cfg    SlowRequestsConfig
+	redact map[string]bool
+
+	mtx    sync.Mutex
+	routes map[string][]SlowRequest
+}
+
+// SetSlowRequests enables capturing the slowest recent requests of every route, exposed as JSON or
+// HTML on the metrics router. It must be called before the middleware is installed.
+func (p *Prometheus) SetSlowRequests(cfg SlowRequestsConfig) {
+	if cfg.Size <= 0 {
+		cfg.Size = defaultSlowRequestsSize
+	}
+	if cfg.MaxAge <= 0 {
+		cfg.MaxAge = defaultSlowRequestsMaxAge
+	}
+	if cfg.RedactHeaders == nil {
+		cfg.RedactHeaders = defaultRedactedHeaders
+	}
+	if cfg.Path == "" {
+		cfg.Path = defaultSlowRequestsPath
+	}
+
+	sr := &slowRequests{
+		cfg:    cfg,
+		redact: map[string]bool{},
+		routes: map[string][]SlowRequest{},
+	}
+	for _, h := range cfg.RedactHeaders {
+		sr.redact[http.CanonicalHeaderKey(h)] = true
+	}
+	p.slowRequests = sr
+}
+
+// SlowRequests returns the captured requests by route, slowest first
+func (p *Prometheus) SlowRequests() map[string][]SlowRequest {
+	if p.slowRequests == nil {
+		return nil
+	}
+	return p.slowRequests.snapshot(time.Now())
+}
+
+func (sr *slowRequests) capture(c *gin.Context, route string, status int, elapsed time.Duration, traceID string, phases []phaseTiming) {
+	if elapsed < sr.cfg.Threshold {
+		return
+	}
+
+	now := time.Now()
+	req := SlowRequest{
+		Time:     now,
+		Method:   c.Request.Method,
+		Path:     c.Request.URL.Path,
+		Route:    route,
+		Status:   status,
+		Duration: elapsed,
+		TraceID:  traceID,
+	}
+	for _, h := range sr.cfg.Headers {
+		if v := c.GetHeader(h); v != "" {
+			if req.Headers == nil {
+				req.Headers = map[string]string{}
+			}
+			if sr.redact[http.CanonicalHeaderKey(h)] {
+				v = redacted
+			}
+			req.Headers[h] = v
+		}
+	}
+	for _, ph := range phases {
+		if req.Phases == nil {
+			req.Phases = map[string]float64{}
+		}
+		req.Phases[ph.name] = ph.duration.Seconds()
+	}
+
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+
+	reqs := sr.evict(sr.routes[route], now)
+	if len(reqs) < sr.cfg.Size {
+		reqs = append(reqs, req)
+	} else {
+		fastest := 0
+		for i := range reqs {
+			if reqs[i].Duration < reqs[fastest].Duration {
+				fastest = i
+			}
+		}
+		if reqs[fastest].Duration >= elapsed {
+			sr.routes[route] = reqs
+			return
+		}
+		reqs[fastest] = req
+	}
+	sr.routes[route] = reqs
+}
+
+// evict removes the requests older than MaxAge
+func (sr *slowRequests) evict(reqs []SlowRequest, now time.Time) []SlowRequest {
+	kept := reqs[:0]
+	for _, r := range reqs {
+		if now.Sub(r.Time) <= sr.cfg.MaxAge {
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+func (sr *slowRequests) snapshot(now time.Time) map[string][]SlowRequest {
+	sr.mtx.Lock()
+	defer sr.mtx.Unlock()
+
+	out := map[string][]SlowRequest{}
+	for route, reqs := range sr.routes {
+		reqs = sr.evict(reqs, now)
+		sr.routes[route] = reqs
+		if len(reqs) == 0 {
+			delete(sr.routes, route)
+			continue
+		}
+		sorted := append([]SlowRequest(nil), reqs...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i].Duration > sorted[j].Duration })
+		out[route] = sorted
+	}
+	return out
+}
+
+var slowRequestsTemplate = template.Must(template.New("slow-requests").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Slow requests</title></head>
+<body>
+{{range $route, $reqs := .}}
+<h2>{{$route}}</h2>
+<table border="1" cellpadding="4">
+<tr><th>Time</th><th>Method</th><th>Path</th><th>Status</th><th>Duration</th><th>Trace ID</th><th>Headers</th><th>Phases (s)</th></tr>
+{{range $reqs}}
+<tr>
+<td>{{.Time.Format "2006-01-02T15:04:05.000Z07:00"}}</td>
+<td>{{.Method}}</td>
+<td>{{.Path}}</td>
+<td>{{.Status}}</td>
+<td>{{.Duration}}</td>
+<td>{{.TraceID}}</td>
+<td>{{range $k, $v := .Headers}}{{$k}}: {{$v}}<br>{{end}}</td>
+<td>{{range $k, $v := .Phases}}{{$k}}: {{printf "%.4f" $v}}<br>{{end}}</td>
+</tr>
+{{end}}
+</table>
+{{else}}
+<p>No slow request captured</p>
+{{end}}
+</body>
+</html>
+`))
+
+func (p *Prometheus) slowRequestsHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		reqs := p.SlowRequests()
+		if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+			c.Header("Content-Type", "text/html; charset=utf-8")
+			c.Status(http.StatusOK)
+			slowRequestsTemplate.Execute(c.Writer, reqs)
+			return
+		}
+		c.JSON(http.StatusOK, reqs)
+	}
+}
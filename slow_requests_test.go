@@ -0,0 +1,93 @@
+package gpmiddleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSlowRequests(t *testing.T) {
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	p.SetSlowRequests(SlowRequestsConfig{
+		Size:    2,
+		Headers: []string{"User-Agent", "Authorization"},
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	p.Use(r)
+	r.GET("/items/:id", func(c *gin.Context) {
+		d, _ := time.ParseDuration(c.Query("sleep"))
+		db := StartPhase(c, "db")
+		time.Sleep(d)
+		db.Stop()
+		c.Status(http.StatusOK)
+	})
+
+	for _, sleep := range []string{"3ms", "1ms", "5ms", "2ms"} {
+		req := httptest.NewRequest(http.MethodGet, "/items/1?sleep="+sleep, nil)
+		req.Header.Set("User-Agent", "test")
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/debug/slow-requests", nil))
+	var got map[string][]SlowRequest
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+
+	reqs := got["GET_/items/:id"]
+	if len(reqs) != 2 {
+		t.Fatalf("expected the 2 slowest requests, got %d", len(reqs))
+	}
+	if reqs[0].Duration < 5*time.Millisecond || reqs[1].Duration < 3*time.Millisecond || reqs[1].Duration >= 5*time.Millisecond {
+		t.Errorf("unexpected durations %v, %v", reqs[0].Duration, reqs[1].Duration)
+	}
+	if reqs[0].Headers["Authorization"] != redacted || reqs[0].Headers["User-Agent"] != "test" {
+		t.Errorf("unexpected headers %v", reqs[0].Headers)
+	}
+	if reqs[0].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("unexpected trace ID %q", reqs[0].TraceID)
+	}
+	if reqs[0].Phases["db"] < 0.005 {
+		t.Errorf("unexpected phases %v", reqs[0].Phases)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/slow-requests", nil)
+	req.Header.Set("Accept", "text/html")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if !strings.Contains(w.Body.String(), "<h2>GET_/items/:id</h2>") {
+		t.Errorf("unexpected HTML page %s", w.Body.String())
+	}
+	if n := testutil.CollectAndCount(p.reqDur); n != 1 {
+		t.Errorf("expected the debug endpoint not to be measured, got %d series", n)
+	}
+}
+
+func TestSlowRequestsMaxAge(t *testing.T) {
+	sr := &slowRequests{
+		cfg:    SlowRequestsConfig{Size: 1, MaxAge: time.Minute},
+		routes: map[string][]SlowRequest{},
+	}
+	sr.routes["GET_/"] = []SlowRequest{{Time: time.Now().Add(-time.Hour), Duration: time.Hour}}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	sr.capture(c, "GET_/", http.StatusOK, time.Millisecond, "", nil)
+
+	reqs := sr.snapshot(time.Now())["GET_/"]
+	if len(reqs) != 1 || reqs[0].Duration != time.Millisecond {
+		t.Errorf("expected the old request to be evicted, got %v", reqs)
+	}
+}
@@ -0,0 +1,101 @@
+package gpmiddleware
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const unixSocketScheme = "unix://"
+
+var defaultUnixSocketMode os.FileMode = 0o660
+
+// UnixSocketConfig configures the socket file of the metrics server when its listen address is a unix://
+// one, e.g. unix:///var/run/app/metrics.sock
+type UnixSocketConfig struct {
+	// Mode of the socket file, defaults to 0660
+	Mode os.FileMode
+	// Group owning the socket file, e.g. the one of a collector scraping it. Defaults to the group of the process
+	Group string
+}
+
+// SetUnixSocket configures the socket file of the metrics server, when SetListenAddress is given a unix://
+// address. A socket file left by a previous process is removed, unless a process is still listening on it.
+func (p *Prometheus) SetUnixSocket(cfg UnixSocketConfig) {
+	p.unixSocket = &cfg
+}
+
+// listenMetrics listens on the address of the metrics server, a TCP or a unix:// one
+func (p *Prometheus) listenMetrics() (net.Listener, error) {
+	path, ok := strings.CutPrefix(p.listenAddress, unixSocketScheme)
+	if !ok {
+		return net.Listen("tcp", p.listenAddress)
+	}
+
+	cfg := UnixSocketConfig{}
+	if p.unixSocket != nil {
+		cfg = *p.unixSocket
+	}
+	if cfg.Mode == 0 {
+		cfg.Mode = defaultUnixSocketMode
+	}
+
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := setSocketPermissions(path, cfg); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// removeStaleSocket removes the socket file at path if no process listens on it anymore
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("%s already exists and is not a socket", path)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is already in use", path)
+	}
+	return os.Remove(path)
+}
+
+func setSocketPermissions(path string, cfg UnixSocketConfig) error {
+	if err := os.Chmod(path, cfg.Mode); err != nil {
+		return err
+	}
+	if cfg.Group == "" {
+		return nil
+	}
+
+	g, err := user.LookupGroup(cfg.Group)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(g.Gid)
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, -1, gid)
+}
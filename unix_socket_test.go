@@ -0,0 +1,147 @@
+//go:build unix
+
+package gpmiddleware
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func unixClient(path string) *http.Client {
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+}
+
+func TestUnixSocketMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+
+	// left by a previous process
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	p.SetUnixSocket(UnixSocketConfig{Mode: 0o600})
+	gin.SetMode(gin.TestMode)
+	p.SetListenAddressWithRouter("unix://"+path, gin.New())
+	r := gin.New()
+	p.UseCustom(r)
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = unixClient(path).Get("http://unix/metrics"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Type() != fs.ModeSocket || fi.Mode().Perm() != 0o600 {
+		t.Errorf("unexpected socket file mode %v", fi.Mode())
+	}
+
+	if _, err := p.listenMetrics(); err == nil || !strings.Contains(err.Error(), "in use") {
+		t.Errorf("expected the socket to be in use, got %v", err)
+	}
+}
+
+func TestUnixSocketNotASocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	p.SetListenAddressWithRouter("unix://"+path, gin.New())
+	if _, err := p.listenMetrics(); err == nil {
+		t.Error("expected an error for a regular file")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the file to be kept, got %v", err)
+	}
+
+	logs := make(chan string, 1)
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(logWriter(logs), nil)))
+	p.UseCustom(gin.New())
+	select {
+	case l := <-logs:
+		if !strings.Contains(l, "not a socket") {
+			t.Errorf("unexpected log %q", l)
+		}
+	case <-time.After(time.Second):
+		t.Error("expected the listen error to be logged")
+	}
+}
+
+type logWriter chan string
+
+func (w logWriter) Write(b []byte) (int, error) {
+	w <- string(b)
+	return len(b), nil
+}
+
+func TestServerUnixSocketMetrics(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+	p := NewPrometheusWithRegistry("gin", prometheus.NewRegistry())
+	gin.SetMode(gin.TestMode)
+	p.SetListenAddressWithRouter("unix://"+path, gin.New())
+	s, err := p.NewServer(gin.New(), ServerConfig{DrainPeriod: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- s.Serve(l) }()
+
+	var resp *http.Response
+	for i := 0; i < 50; i++ {
+		if resp, err = unixClient(path).Get("http://unix/metrics"); err == nil {
+			break
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	s.Stop()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed on shutdown, got %v", err)
+	}
+}